@@ -0,0 +1,238 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	redis "github.com/vmihailenco/redis/v2"
+)
+
+// redis key of the hash holding the banned client IPs, by IP
+const bansKey = "bans"
+
+// increments the counter in KEYS[1], starting it with a lifetime of ARGV[1] seconds. Returns the
+// new count.
+var countScript = `
+local count = redis.call('INCR', KEYS[1])
+if count == 1 then
+	redis.call('EXPIRE', KEYS[1], ARGV[1])
+end
+return count`
+
+// Ban keeps a client IP from using any of the rate limited routes until it expires.
+type Ban struct {
+	IP      string `json:"ip"`
+	Reason  string `json:"reason"`
+	Created int64  `json:"created"`
+	Expires int64  `json:"expires"`
+}
+
+// BanRequest may be sent to /api/1/bans to clear bans.
+type BanRequest struct {
+	// IP to lift the ban on
+	Clear string `json:"clear,omitempty"`
+	// lift every ban
+	ClearAll bool `json:"clearAll,omitempty"`
+}
+
+// validateProxies checks that every trusted proxy is an IP or a CIDR.
+func validateProxies(proxies []string) error {
+	for _, p := range proxies {
+		if net.ParseIP(p) == nil {
+			if _, _, err := net.ParseCIDR(p); err != nil {
+				return fmt.Errorf("%q is not an IP or a CIDR.", p)
+			}
+		}
+	}
+	return nil
+}
+
+// isTrustedProxy returns true if ip is one of the config's TrustedProxies.
+func isTrustedProxy(ip net.IP) bool {
+	for _, p := range config.TrustedProxies {
+		if trusted := net.ParseIP(p); trusted != nil {
+			if trusted.Equal(ip) {
+				return true
+			}
+		} else if _, network, err := net.ParseCIDR(p); err == nil && network.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIP returns the IP of the client that sent r. If the request came through trusted proxies,
+// the client is the last address in X-Forwarded-For that isn't one of them, since anything before
+// it may have been made up by the client.
+func clientIP(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	ip := net.ParseIP(host)
+	if ip == nil || !isTrustedProxy(ip) {
+		return host
+	}
+
+	forwarded := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(forwarded) - 1; i >= 0; i-- {
+		addr := strings.TrimSpace(forwarded[i])
+		hop := net.ParseIP(addr)
+		if hop == nil {
+			// whatever came before an address that can't be read can't be trusted either
+			break
+		}
+		host = addr
+		if !isTrustedProxy(hop) {
+			break
+		}
+	}
+	return host
+}
+
+// incrCounter increments the counter with the given key, which lasts for the given time once
+// started. It returns the new count.
+func incrCounter(key string, lifetime time.Duration) (int64, error) {
+	res := client.Eval(countScript, []string{key}, []string{fmt.Sprint(int64(lifetime / time.Second))})
+	if res.Err() != nil {
+		log.Println("Failure to count", key, res.Err())
+		return 0, res.Err()
+	}
+	n, _ := res.Val().(int64)
+	return n, nil
+}
+
+// countBinCreation counts a bin being created against the config's MaxBinsPerHour. It returns
+// false, along with how long until the next hour, if the cap has been reached.
+func countBinCreation(now time.Time) (bool, time.Duration, error) {
+	if config.MaxBinsPerHour <= 0 {
+		return true, 0, nil
+	}
+
+	hour := now.Truncate(time.Hour)
+	n, err := incrCounter(fmt.Sprint("bins-created:", hour.Unix()), time.Hour)
+	if err != nil {
+		return false, 0, err
+	}
+	if n > config.MaxBinsPerHour {
+		return false, hour.Add(time.Hour).Sub(now), nil
+	}
+	return true, 0, nil
+}
+
+// addStrike records a client going over one of its rate limits, banning it once it has done so
+// BanThreshold times within BanWindow seconds.
+func addStrike(ip, route string, now time.Time) {
+	if config.BanThreshold <= 0 || config.BanDuration <= 0 {
+		return
+	}
+
+	window := time.Duration(config.BanWindow) * time.Second
+	if window <= 0 {
+		window = time.Minute
+	}
+	n, err := incrCounter("rate-limit-strikes:"+ip, window)
+	if err != nil || n < config.BanThreshold {
+		return
+	}
+
+	reason := fmt.Sprintf("went over the %s rate limit %d times within %v", route, n, window)
+	if err := banIP(ip, reason, time.Duration(config.BanDuration)*time.Second, now); err == nil {
+		client.Del("rate-limit-strikes:" + ip)
+	}
+}
+
+// banIP bans the given client IP for the given time.
+func banIP(ip, reason string, d time.Duration, now time.Time) error {
+	ban := Ban{IP: ip, Reason: reason, Created: now.Unix(), Expires: now.Add(d).Unix()}
+	encoded, err := json.Marshal(ban)
+	if err != nil {
+		log.Println("Error marshalling ban:", err)
+		return err
+	}
+	if res := client.HSet(bansKey, ip, string(encoded)); res.Err() != nil {
+		log.Println("Failure to HSET ban for", ip, res.Err())
+		return res.Err()
+	}
+	log.Println("Banned", ip, "until", time.Unix(ban.Expires, 0).UTC(), "as it", reason)
+	return nil
+}
+
+// getBan returns the ban on the given client IP, or nil if it isn't banned.
+func getBan(ip string, now time.Time) (*Ban, error) {
+	res, err := client.HGet(bansKey, ip).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println("Failure to HGET ban for", ip, err)
+		return nil, err
+	}
+
+	var ban Ban
+	if err := json.Unmarshal([]byte(res), &ban); err != nil {
+		log.Println("Error unmarshalling ban for", ip, err)
+		return nil, err
+	}
+	if ban.Expires <= now.Unix() {
+		client.HDel(bansKey, ip)
+		return nil, nil
+	}
+	return &ban, nil
+}
+
+// getBans returns the bans that haven't expired, forgetting the rest.
+func getBans(now time.Time) ([]Ban, error) {
+	res, err := client.HGetAllMap(bansKey).Result()
+	if err != nil {
+		log.Println("Failure to HGETALL bans", err)
+		return nil, err
+	}
+
+	bans := make([]Ban, 0, len(res))
+	for ip, v := range res {
+		var ban Ban
+		if err := json.Unmarshal([]byte(v), &ban); err != nil {
+			log.Println("Error unmarshalling ban for", ip, err)
+			continue
+		}
+		if ban.Expires <= now.Unix() {
+			client.HDel(bansKey, ip)
+			continue
+		}
+		bans = append(bans, ban)
+	}
+	return bans, nil
+}
+
+// validate checks that the request picks out bans to lift.
+func (br BanRequest) validate() error {
+	if br.Clear == "" && !br.ClearAll {
+		return errors.New("clear must be an IP, or clearAll true.")
+	}
+	return nil
+}
+
+// clearBans lifts the bans picked out by br.
+func clearBans(br BanRequest) error {
+	if br.ClearAll {
+		if res := client.Del(bansKey); res.Err() != nil {
+			log.Println("Failure to DEL bans", res.Err())
+			return res.Err()
+		}
+		return nil
+	}
+
+	if res := client.HDel(bansKey, br.Clear); res.Err() != nil {
+		log.Println("Failure to HDEL ban for", br.Clear, res.Err())
+		return res.Err()
+	}
+	client.Del("rate-limit-strikes:" + br.Clear)
+	return nil
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestValidateProxies(t *testing.T) {
+	assert.Equal(t, nil, validateProxies([]string{"10.0.0.1", "192.168.0.0/16", "::1"}))
+	assert.NotEqual(t, nil, validateProxies([]string{"proxy.example.com"}))
+	assert.NotEqual(t, nil, validateProxies([]string{"10.0.0.0/33"}))
+}
+
+func TestClientIP(t *testing.T) {
+	proxies := config.TrustedProxies
+	defer func() { config.TrustedProxies = proxies }()
+	config.TrustedProxies = []string{"10.0.0.1", "192.168.0.0/16"}
+
+	ip := func(remote string, forwarded ...string) string {
+		r, _ := http.NewRequest("POST", "http://testing.geobin.io/api/1/create", nil)
+		r.RemoteAddr = remote
+		for _, f := range forwarded {
+			r.Header.Add("X-Forwarded-For", f)
+		}
+		return clientIP(r)
+	}
+
+	// X-Forwarded-For is ignored unless the request came from a trusted proxy
+	assert.Equal(t, "203.0.113.7", ip("203.0.113.7:1234"))
+	assert.Equal(t, "203.0.113.7", ip("203.0.113.7:1234", "198.51.100.1"))
+
+	assert.Equal(t, "198.51.100.1", ip("10.0.0.1:1234", "198.51.100.1"))
+	assert.Equal(t, "198.51.100.1", ip("10.0.0.1:1234", "6.6.6.6, 198.51.100.1, 192.168.1.2"))
+	assert.Equal(t, "198.51.100.1", ip("10.0.0.1:1234", "6.6.6.6", "198.51.100.1, 192.168.1.2"))
+	assert.Equal(t, "10.0.0.1", ip("10.0.0.1:1234"))
+	assert.Equal(t, "192.168.1.2", ip("10.0.0.1:1234", "not-an-ip, 192.168.1.2"))
+}
+
+func TestBanRequestValidate(t *testing.T) {
+	assert.Equal(t, nil, BanRequest{Clear: "203.0.113.7"}.validate())
+	assert.Equal(t, nil, BanRequest{ClearAll: true}.validate())
+	assert.NotEqual(t, nil, BanRequest{}.validate())
+}
@@ -0,0 +1,175 @@
+package main
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"net/http"
+	"strings"
+)
+
+// name of the settings field holding a private bin's access tokens
+const accessSetting = "access"
+
+// header that access tokens may be sent in, as well as "Authorization: Bearer {token}"
+const tokenHeader = "X-Geobin-Token"
+
+// What a request wants to do with a bin.
+type accessLevel int
+
+const (
+	// view the bin's requests, through its history or live, which its share links may also do
+	viewAccess accessLevel = iota
+	// read anything about the bin, like its tracks or settings
+	readAccess
+	// send requests to the bin and change its settings
+	writeAccess
+	// delete or share the bin, only allowed to the account owning it
+	ownerAccess
+	// pin the bin, only allowed with the config's AdminKey
+	adminAccess
+)
+
+// BinAccess holds the access tokens of a private bin. Public bins have none.
+type BinAccess struct {
+	ReadToken  string `json:"readToken"`
+	WriteToken string `json:"writeToken"`
+}
+
+// newBinAccess returns a BinAccess with newly generated tokens.
+func newBinAccess() (BinAccess, error) {
+	read, err := randomToken()
+	if err != nil {
+		return BinAccess{}, err
+	}
+	write, err := randomToken()
+	if err != nil {
+		return BinAccess{}, err
+	}
+	return BinAccess{ReadToken: read, WriteToken: write}, nil
+}
+
+// randomToken returns a random, unguessable token.
+func randomToken() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// allows returns true if token grants the given level of access. The write token grants every
+// level, the read token only viewing and reading.
+func (ba BinAccess) allows(token string, level accessLevel) bool {
+	if tokensEqual(token, ba.WriteToken) {
+		return true
+	}
+	return (level == viewAccess || level == readAccess) && tokensEqual(token, ba.ReadToken)
+}
+
+// tokensEqual compares a token from a request with a stored one in constant time.
+func tokensEqual(given, stored string) bool {
+	return given != "" && subtle.ConstantTimeCompare([]byte(given), []byte(stored)) == 1
+}
+
+// getAccess returns the access tokens of the given bin, or nil if the bin is public.
+func getAccess(name string) (*BinAccess, error) {
+	var ba BinAccess
+	found, err := getBinSetting(name, accessSetting, &ba)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &ba, nil
+}
+
+// isAdmin returns true if token is the AdminKey from the config.
+func isAdmin(token string) bool {
+	return config.AdminKey != "" && tokensEqual(token, config.AdminKey)
+}
+
+// requestToken returns the access token sent with r, if any. It is read from the X-Geobin-Token
+// header, an "Authorization: Bearer" header or the `token` query parameter, in that order.
+func requestToken(r *http.Request) string {
+	if token := r.Header.Get(tokenHeader); token != "" {
+		return token
+	}
+	if auth := r.Header.Get("Authorization"); strings.HasPrefix(auth, "Bearer ") {
+		return strings.TrimPrefix(auth, "Bearer ")
+	}
+	return r.URL.Query().Get("token")
+}
+
+// stripToken removes the access token that requestToken reads from r from headers, which hold
+// r's headers, so that it is never stored along with the request. An Authorization header is
+// only removed if it is the one the token was read from.
+func stripToken(r *http.Request, headers map[string]string) {
+	delete(headers, tokenHeader)
+	if r.Header.Get(tokenHeader) == "" && strings.HasPrefix(r.Header.Get("Authorization"), "Bearer ") {
+		delete(headers, "Authorization")
+	}
+}
+
+// checkAccess returns true if token grants the given level of access to the given bin. Anyone
+// may read or write a public bin. The bin's share links can only view it, the API keys of the accounts
+// owning a bin, or that it was shared with, can do anything the write token can, and the admin key
+// can do anything at all.
+func checkAccess(name, token string, level accessLevel) (bool, error) {
+	if isAdmin(token) {
+		return true, nil
+	}
+	if level == adminAccess {
+		return false, nil
+	}
+
+	if level != ownerAccess {
+		ba, err := getAccess(name)
+		if err != nil {
+			return false, err
+		}
+		if ba == nil || ba.allows(token, level) {
+			return true, nil
+		}
+	}
+
+	// share links only let the bin's requests be viewed, the rest of its data may hold requests
+	// outside of their window
+	if level == viewAccess {
+		link, err := getLink(name, token)
+		if err != nil {
+			return false, err
+		}
+		if link != nil {
+			return true, nil
+		}
+	}
+
+	role, err := tokenRole(name, token)
+	if err != nil {
+		return false, err
+	}
+	if level == ownerAccess {
+		return role == ownerRole, nil
+	}
+	return role != "", nil
+}
+
+// authorize checks that r grants the given level of access to the given bin. If it doesn't, an
+// error is written to w and false is returned.
+func authorize(w http.ResponseWriter, r *http.Request, name string, level accessLevel) bool {
+	token := requestToken(r)
+	ok, err := checkAccess(name, token, level)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return false
+	}
+	if ok {
+		return true
+	}
+
+	if token == "" {
+		http.Error(w, "This bin is private, a token is required.", http.StatusUnauthorized)
+	} else {
+		http.Error(w, "The token does not grant access to this bin.", http.StatusForbidden)
+	}
+	return false
+}
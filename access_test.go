@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestBinAccessAllows(t *testing.T) {
+	ba, err := newBinAccess()
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, ba.ReadToken, ba.WriteToken)
+
+	assert.T(t, ba.allows(ba.ReadToken, viewAccess))
+	assert.T(t, ba.allows(ba.ReadToken, readAccess))
+	assert.T(t, !ba.allows(ba.ReadToken, writeAccess))
+	assert.T(t, ba.allows(ba.WriteToken, readAccess))
+	assert.T(t, ba.allows(ba.WriteToken, writeAccess))
+	assert.T(t, !ba.allows("", readAccess))
+	assert.T(t, !ba.allows("nope", readAccess))
+}
+
+func TestRequestToken(t *testing.T) {
+	req, _ := http.NewRequest("GET", "http://testing.geobin.io/?token=query", nil)
+	assert.Equal(t, "query", requestToken(req))
+
+	req.Header.Set("Authorization", "Bearer bearer")
+	assert.Equal(t, "bearer", requestToken(req))
+
+	req.Header.Set(tokenHeader, "header")
+	assert.Equal(t, "header", requestToken(req))
+
+	req, _ = http.NewRequest("GET", "http://testing.geobin.io/", nil)
+	req.Header.Set("Authorization", "Basic abc")
+	assert.Equal(t, "", requestToken(req))
+}
+
+func TestStripToken(t *testing.T) {
+	req, _ := http.NewRequest("POST", "http://testing.geobin.io/", nil)
+	req.Header.Set("Authorization", "Bearer bearer")
+	headers := map[string]string{"Authorization": "Bearer bearer", "Content-Type": "application/json"}
+	stripToken(req, headers)
+	assert.Equal(t, map[string]string{"Content-Type": "application/json"}, headers)
+
+	// the Authorization header is kept when the token came from somewhere else
+	req.Header.Set(tokenHeader, "header")
+	headers = map[string]string{"Authorization": "Bearer bearer", tokenHeader: "header"}
+	stripToken(req, headers)
+	assert.Equal(t, map[string]string{"Authorization": "Bearer bearer"}, headers)
+
+	req, _ = http.NewRequest("POST", "http://testing.geobin.io/", nil)
+	req.Header.Set("Authorization", "Basic abc")
+	headers = map[string]string{"Authorization": "Basic abc"}
+	stripToken(req, headers)
+	assert.Equal(t, map[string]string{"Authorization": "Basic abc"}, headers)
+}
+
+func TestCheckAccess(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	// public bins are open to everyone
+	ok, err := checkAccess(binId, "", writeAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, ok)
+
+	ba, err := newBinAccess()
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, setBinSetting(binId, accessSetting, ba))
+
+	ok, err = checkAccess(binId, "", readAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, !ok)
+
+	ok, err = checkAccess(binId, ba.ReadToken, readAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, ok)
+
+	ok, err = checkAccess(binId, ba.ReadToken, writeAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, !ok)
+}
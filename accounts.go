@@ -0,0 +1,376 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	redis "github.com/vmihailenco/redis/v2"
+)
+
+// names of the settings fields holding the account that owns a bin and the accounts it is shared
+// with
+const (
+	ownerSetting   = "owner"
+	membersSetting = "members"
+)
+
+// The part an account plays in a bin.
+const (
+	ownerRole  = "owner"
+	memberRole = "member"
+)
+
+// PBKDF2 iterations used to hash passwords
+const passwordIterations = 10000
+
+// the characters and length allowed in usernames
+var usernamePattern = regexp.MustCompile(`^[a-zA-Z0-9_.-]{3,32}$`)
+
+var errAccountExists = errors.New("That username is taken.")
+
+// Account is a user of geobin. Its API key is sent as a token to act as the account.
+type Account struct {
+	Username string `json:"username"`
+	APIKey   string `json:"apiKey"`
+	Created  int64  `json:"created"`
+	// PBKDF2 hash of the password, empty for accounts only used with their API key
+	Password string `json:"password,omitempty"`
+}
+
+// AccountBin describes a bin owned by, or shared with, an account.
+type AccountBin struct {
+	ID      string `json:"id"`
+	Expires int64  `json:"expires"` // 0 if the bin doesn't expire
+	Role    string `json:"role"`
+}
+
+// accountKey returns the redis key of the given account.
+func accountKey(username string) string {
+	return "account:" + username
+}
+
+// apiKeyKey returns the redis key holding the username of the account with the given API key.
+func apiKeyKey(key string) string {
+	return "api-key:" + key
+}
+
+// accountBinsKey returns the redis key of the set of bins owned by, or shared with, the given
+// account.
+func accountBinsKey(username string) string {
+	return "account-bins:" + username
+}
+
+// createAccount creates an account with a new API key. The password may be empty.
+func createAccount(username, password string) (*Account, error) {
+	if !usernamePattern.MatchString(username) {
+		return nil, errors.New("username must be 3 to 32 letters, digits, dots, dashes or underscores.")
+	}
+
+	key, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	a := &Account{Username: username, APIKey: key, Created: time.Now().UTC().Unix()}
+	if password != "" {
+		if a.Password, err = hashPassword(password); err != nil {
+			return nil, err
+		}
+	}
+
+	encoded, err := json.Marshal(a)
+	if err != nil {
+		log.Println("Error marshalling account:", err)
+		return nil, err
+	}
+
+	created, err := client.SetNX(accountKey(username), string(encoded)).Result()
+	if err != nil {
+		log.Println("Failure to SETNX account", username, err)
+		return nil, err
+	}
+	if !created {
+		return nil, errAccountExists
+	}
+
+	if res := client.Set(apiKeyKey(key), username); res.Err() != nil {
+		log.Println("Failure to SET API key for", username, res.Err())
+		return nil, res.Err()
+	}
+	return a, nil
+}
+
+// getAccount returns the account with the given username, or nil if there is none.
+func getAccount(username string) (*Account, error) {
+	res, err := client.Get(accountKey(username)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println("Failure to GET account", username, err)
+		return nil, err
+	}
+
+	var a Account
+	if err := json.Unmarshal([]byte(res), &a); err != nil {
+		log.Println("Error unmarshalling account", username, err)
+		return nil, err
+	}
+	return &a, nil
+}
+
+// accountForKey returns the account with the given API key, or nil if there is none.
+func accountForKey(key string) (*Account, error) {
+	if key == "" {
+		return nil, nil
+	}
+
+	username, err := client.Get(apiKeyKey(key)).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println("Failure to GET API key", err)
+		return nil, err
+	}
+	return getAccount(username)
+}
+
+// login returns the account with the given username and password, or nil if they don't match.
+func login(username, password string) (*Account, error) {
+	a, err := getAccount(username)
+	if err != nil || a == nil {
+		return nil, err
+	}
+	if a.Password == "" || !checkPassword(a.Password, password) {
+		return nil, nil
+	}
+	return a, nil
+}
+
+// hashPassword returns a salted PBKDF2 hash of password, as "{iterations}${salt}${hash}".
+func hashPassword(password string) (string, error) {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return "", err
+	}
+	hash := pbkdf2SHA256([]byte(password), salt, passwordIterations)
+	return fmt.Sprintf("%d$%s$%s", passwordIterations, hex.EncodeToString(salt), hex.EncodeToString(hash)), nil
+}
+
+// checkPassword returns true if password matches a hash made by hashPassword.
+func checkPassword(hashed, password string) bool {
+	parts := strings.Split(hashed, "$")
+	if len(parts) != 3 {
+		return false
+	}
+	iterations, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return false
+	}
+	salt, err := hex.DecodeString(parts[1])
+	if err != nil {
+		return false
+	}
+	expected, err := hex.DecodeString(parts[2])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(pbkdf2SHA256([]byte(password), salt, iterations), expected)
+}
+
+// pbkdf2SHA256 derives a 32 byte key from password and salt, as described in RFC 2898.
+func pbkdf2SHA256(password, salt []byte, iterations int) []byte {
+	mac := hmac.New(sha256.New, password)
+	mac.Write(salt)
+	mac.Write([]byte{0, 0, 0, 1})
+	u := mac.Sum(nil)
+
+	key := make([]byte, len(u))
+	copy(key, u)
+	for i := 1; i < iterations; i++ {
+		mac.Reset()
+		mac.Write(u)
+		u = mac.Sum(u[:0])
+		for j := range key {
+			key[j] ^= u[j]
+		}
+	}
+	return key
+}
+
+// getOwner returns the username of the account that owns the given bin, or "" if nobody does.
+func getOwner(name string) (string, error) {
+	var owner string
+	_, err := getBinSetting(name, ownerSetting, &owner)
+	return owner, err
+}
+
+// getMembers returns the usernames of the accounts the given bin is shared with.
+func getMembers(name string) ([]string, error) {
+	members := make([]string, 0)
+	_, err := getBinSetting(name, membersSetting, &members)
+	return members, err
+}
+
+// binRole returns the part the given account plays in a bin, or "" if it has none.
+func binRole(name, username string) (string, error) {
+	owner, err := getOwner(name)
+	if err != nil {
+		return "", err
+	}
+	if owner != "" && owner == username {
+		return ownerRole, nil
+	}
+
+	members, err := getMembers(name)
+	if err != nil {
+		return "", err
+	}
+	for _, m := range members {
+		if m == username {
+			return memberRole, nil
+		}
+	}
+	return "", nil
+}
+
+// tokenRole returns the part played in a bin by the account whose API key is token, or "" if it
+// plays none.
+func tokenRole(name, token string) (string, error) {
+	a, err := accountForKey(token)
+	if err != nil || a == nil {
+		return "", err
+	}
+	return binRole(name, a.Username)
+}
+
+// ownBin makes the given account the owner of a bin.
+func ownBin(name, username string) error {
+	if err := setBinSetting(name, ownerSetting, username); err != nil {
+		return err
+	}
+	if res := client.SAdd(accountBinsKey(username), name); res.Err() != nil {
+		log.Println("Failure to SADD", name, "to the bins of", username, res.Err())
+		return res.Err()
+	}
+	return nil
+}
+
+// shareBin replaces the accounts the given bin is shared with. Every account must exist.
+func shareBin(name string, members []string) error {
+	for _, m := range members {
+		a, err := getAccount(m)
+		if err != nil {
+			return err
+		}
+		if a == nil {
+			return fmt.Errorf("There is no account %q.", m)
+		}
+	}
+
+	old, err := getMembers(name)
+	if err != nil {
+		return err
+	}
+	if err := setBinSetting(name, membersSetting, members); err != nil {
+		return err
+	}
+
+	for _, m := range old {
+		client.SRem(accountBinsKey(m), name)
+	}
+	for _, m := range members {
+		if res := client.SAdd(accountBinsKey(m), name); res.Err() != nil {
+			log.Println("Failure to SADD", name, "to the bins of", m, res.Err())
+			return res.Err()
+		}
+	}
+	return nil
+}
+
+// getAccountBins returns the bins owned by, or shared with, the given account, soonest to expire
+// first. Bins that have expired are forgotten.
+func getAccountBins(username string) ([]AccountBin, error) {
+	names, err := client.SMembers(accountBinsKey(username)).Result()
+	if err != nil {
+		log.Println("Failure to SMEMBERS the bins of", username, err)
+		return nil, err
+	}
+
+	bins := make([]AccountBin, 0, len(names))
+	for _, name := range names {
+		exists, err := nameExists(name)
+		if err != nil {
+			return nil, err
+		}
+		role := ""
+		if exists {
+			if role, err = binRole(name, username); err != nil {
+				return nil, err
+			}
+		}
+		if role == "" {
+			client.SRem(accountBinsKey(username), name)
+			continue
+		}
+
+		expires, err := binExpires(name)
+		if err != nil {
+			return nil, err
+		}
+		bins = append(bins, AccountBin{ID: name, Expires: expires, Role: role})
+	}
+
+	sort.Sort(accountBinsByExpiry(bins))
+	return bins, nil
+}
+
+// accountBinsByExpiry sorts bins by when they expire, with bins that don't expire last.
+type accountBinsByExpiry []AccountBin
+
+func (b accountBinsByExpiry) Len() int      { return len(b) }
+func (b accountBinsByExpiry) Swap(i, j int) { b[i], b[j] = b[j], b[i] }
+func (b accountBinsByExpiry) Less(i, j int) bool {
+	if b[i].Expires == 0 || b[j].Expires == 0 {
+		return b[j].Expires == 0 && b[i].Expires != 0
+	}
+	return b[i].Expires < b[j].Expires
+}
+
+// deleteBin removes the given bin, everything stored alongside it, and its place in the bins of
+// the accounts that own it or it was shared with. Sockets watching the bin are told and dropped.
+func deleteBin(name string) error {
+	owner, err := getOwner(name)
+	if err != nil {
+		return err
+	}
+	members, err := getMembers(name)
+	if err != nil {
+		return err
+	}
+
+	keys := append([]string{name, viewersKey(name)}, binDataKeys(name)...)
+	if res := client.Del(keys...); res.Err() != nil {
+		log.Println("Failure to DEL", name, res.Err())
+		return res.Err()
+	}
+	publishNotice(name, SocketNotice{Type: "deleted", Bin: name})
+
+	for _, username := range append(members, owner) {
+		if username != "" {
+			client.SRem(accountBinsKey(username), name)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package main
+
+import (
+	"encoding/hex"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPBKDF2SHA256(t *testing.T) {
+	// test vectors from RFC 7914
+	assert.Equal(t, "55ac046e56e3089fec1691c22544b605f94185216dde0465e68b9d57c20dacbc", hex.EncodeToString(pbkdf2SHA256([]byte("passwd"), []byte("salt"), 1)))
+	assert.Equal(t, "4ddcd8f60b98be21830cee5ef22701f9641a4418d04c0414aeff08876b34ab56", hex.EncodeToString(pbkdf2SHA256([]byte("Password"), []byte("NaCl"), 80000)))
+}
+
+func TestPasswords(t *testing.T) {
+	hashed, err := hashPassword("hunter2")
+	assert.Equal(t, nil, err)
+	assert.T(t, checkPassword(hashed, "hunter2"))
+	assert.T(t, !checkPassword(hashed, "hunter3"))
+	assert.T(t, !checkPassword("garbage", "hunter2"))
+
+	// salted, so the same password hashes differently
+	again, err := hashPassword("hunter2")
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, hashed, again)
+}
+
+func TestAccounts(t *testing.T) {
+	username := fmt.Sprint("test-", time.Now().UnixNano())
+	a, err := createAccount(username, "hunter2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, username, a.Username)
+
+	_, err = createAccount(username, "other")
+	assert.Equal(t, errAccountExists, err)
+	_, err = createAccount("no spaces allowed", "")
+	assert.NotEqual(t, nil, err)
+
+	found, err := accountForKey(a.APIKey)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, a, found)
+
+	found, err = login(username, "hunter2")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, a, found)
+	found, err = login(username, "hunter3")
+	assert.Equal(t, nil, err)
+	assert.Equal(t, (*Account)(nil), found)
+}
+
+func TestBinOwnership(t *testing.T) {
+	owner, err := createAccount(fmt.Sprint("owner-", time.Now().UnixNano()), "")
+	assert.Equal(t, nil, err)
+	member, err := createAccount(fmt.Sprint("member-", time.Now().UnixNano()), "")
+	assert.Equal(t, nil, err)
+
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+	assert.Equal(t, nil, ownBin(binId, owner.Username))
+	assert.Equal(t, nil, shareBin(binId, []string{member.Username}))
+	assert.NotEqual(t, nil, shareBin(binId, []string{"nobody-has-this-name"}))
+
+	role, err := tokenRole(binId, owner.APIKey)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, ownerRole, role)
+	role, err = tokenRole(binId, member.APIKey)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, memberRole, role)
+
+	// members can write to the bin, but only its owner can manage it
+	ok, err := checkAccess(binId, member.APIKey, writeAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, ok)
+	ok, err = checkAccess(binId, member.APIKey, ownerAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, !ok)
+	ok, err = checkAccess(binId, owner.APIKey, ownerAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, ok)
+
+	bins, err := getAccountBins(member.Username)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(bins))
+	assert.Equal(t, binId, bins[0].ID)
+	assert.Equal(t, memberRole, bins[0].Role)
+
+	// unsharing takes the bin off the member's list
+	assert.Equal(t, nil, shareBin(binId, []string{}))
+	bins, err = getAccountBins(member.Username)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(bins))
+
+	// and deleting it off the owner's
+	assert.Equal(t, nil, deleteBin(binId))
+	exists, err := nameExists(binId)
+	assert.Equal(t, nil, err)
+	assert.T(t, !exists)
+	bins, err = getAccountBins(owner.Username)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(bins))
+}
@@ -0,0 +1,148 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+)
+
+// What a socket does with a new message when its client has fallen behind and its outbound
+// buffer is full.
+const (
+	// drop the oldest buffered message to make room for the new one
+	dropOldest = "drop-oldest"
+	// drop the new message
+	dropNewest = "drop-newest"
+	// drop the new message, and close the socket once MaxMissed messages have been dropped
+	disconnect = "disconnect"
+)
+
+const (
+	// number of outbound messages buffered for each socket
+	outboxSize = 256
+	// messages a socket using the disconnect policy may miss before it is closed, by default
+	defaultMaxMissed = 256
+)
+
+// total number of messages dropped by every socket since the server started, see statsHandler
+var droppedMessages uint64
+
+// Backpressure describes how a socket handles a client that can't keep up.
+type Backpressure struct {
+	Policy    string
+	MaxMissed int
+}
+
+// defaultBackpressure is used by sockets that don't ask for anything else.
+var defaultBackpressure = Backpressure{Policy: dropOldest, MaxMissed: defaultMaxMissed}
+
+// backpressureFromRequest reads a Backpressure from the `policy` and `maxMissed` query parameters
+// of r, falling back to defaultBackpressure.
+func backpressureFromRequest(r *http.Request) (Backpressure, error) {
+	bp := defaultBackpressure
+	query := r.URL.Query()
+
+	if v := query.Get("policy"); v != "" {
+		if v != dropOldest && v != dropNewest && v != disconnect {
+			return bp, fmt.Errorf("policy must be one of %s, %s or %s.", dropOldest, dropNewest, disconnect)
+		}
+		bp.Policy = v
+	}
+
+	if v := query.Get("maxMissed"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 {
+			return bp, errors.New("maxMissed must be a number greater than 0.")
+		}
+		bp.MaxMissed = n
+	}
+
+	return bp, nil
+}
+
+// outbox is the buffer of messages waiting to be written to a socket. Adding to it never blocks:
+// when it is full, messages are dropped according to its Backpressure policy. Once there is room
+// again, a notice telling the client how many messages it missed is added ahead of the next one.
+type outbox struct {
+	lk sync.Mutex
+	ch chan []byte
+	bp Backpressure
+
+	// messages dropped since the client was last told about it
+	missed int
+	// messages dropped in total
+	dropped uint64
+	// true once onOverflow has been called
+	overflowed bool
+
+	// called, from its own goroutine, when a socket using the disconnect policy misses too many
+	// messages
+	onOverflow func()
+}
+
+// newOutbox returns an empty outbox using the given Backpressure.
+func newOutbox(bp Backpressure, onOverflow func()) *outbox {
+	if onOverflow == nil {
+		onOverflow = func() {}
+	}
+
+	return &outbox{
+		ch:         make(chan []byte, outboxSize),
+		bp:         bp,
+		onOverflow: onOverflow,
+	}
+}
+
+// push adds payload to the outbox.
+func (o *outbox) push(payload []byte) {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+
+	// tell the client what it missed as soon as there's room for the notice and the new message
+	if o.missed > 0 && len(o.ch) < cap(o.ch)-1 {
+		notice, err := json.Marshal(SocketNotice{Type: "missed", Count: o.missed})
+		if err != nil {
+			log.Println("Error marshalling socket notice:", err)
+		} else {
+			o.ch <- notice
+			o.missed = 0
+		}
+	}
+
+	select {
+	case o.ch <- payload:
+		return
+	default:
+	}
+
+	// the client has fallen behind
+	o.missed++
+	o.dropped++
+	atomic.AddUint64(&droppedMessages, 1)
+
+	switch o.bp.Policy {
+	case dropOldest:
+		select {
+		case <-o.ch:
+		default:
+		}
+		o.ch <- payload
+	case disconnect:
+		if o.missed >= o.bp.MaxMissed && !o.overflowed {
+			o.overflowed = true
+			go o.onOverflow()
+		}
+	}
+}
+
+// droppedCount returns the number of messages the outbox has dropped.
+func (o *outbox) droppedCount() uint64 {
+	o.lk.Lock()
+	defer o.lk.Unlock()
+	return o.dropped
+}
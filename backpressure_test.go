@@ -0,0 +1,108 @@
+package main
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestBackpressureFromRequest(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected Backpressure
+		valid    bool
+	}{
+		{"", defaultBackpressure, true},
+		{"policy=drop-newest", Backpressure{Policy: dropNewest, MaxMissed: defaultMaxMissed}, true},
+		{"policy=disconnect&maxMissed=10", Backpressure{Policy: disconnect, MaxMissed: 10}, true},
+		{"policy=ignore", Backpressure{}, false},
+		{"maxMissed=0", Backpressure{}, false},
+		{"maxMissed=lots", Backpressure{}, false},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest("GET", "http://testing.geobin.io/api/1/ws/bin?"+test.query, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		bp, err := backpressureFromRequest(r)
+		assert.Equal(t, test.valid, err == nil, test.query)
+		if test.valid {
+			assert.Equal(t, test.expected, bp, test.query)
+		}
+	}
+}
+
+// drain returns everything in the outbox.
+func drain(o *outbox) []string {
+	messages := make([]string, 0)
+	for {
+		select {
+		case m := <-o.ch:
+			messages = append(messages, string(m))
+		default:
+			return messages
+		}
+	}
+}
+
+// fill pushes messages into the outbox until it is full.
+func fill(o *outbox) {
+	for len(o.ch) < cap(o.ch) {
+		o.push([]byte("old"))
+	}
+}
+
+func TestOutboxDropOldest(t *testing.T) {
+	o := newOutbox(Backpressure{Policy: dropOldest}, nil)
+	fill(o)
+	o.push([]byte("new"))
+
+	messages := drain(o)
+	assert.Equal(t, outboxSize, len(messages))
+	assert.Equal(t, "new", messages[len(messages)-1])
+	assert.Equal(t, uint64(1), o.droppedCount())
+
+	// the client is told what it missed before the next message
+	o.push([]byte("next"))
+	assert.Equal(t, []string{`{"type":"missed","count":1}`, "next"}, drain(o))
+}
+
+func TestOutboxDropNewest(t *testing.T) {
+	o := newOutbox(Backpressure{Policy: dropNewest}, nil)
+	fill(o)
+	o.push([]byte("new"))
+	o.push([]byte("newer"))
+
+	messages := drain(o)
+	assert.Equal(t, outboxSize, len(messages))
+	assert.Equal(t, "old", messages[len(messages)-1])
+	assert.Equal(t, uint64(2), o.droppedCount())
+
+	o.push([]byte("next"))
+	assert.Equal(t, []string{`{"type":"missed","count":2}`, "next"}, drain(o))
+}
+
+func TestOutboxDisconnect(t *testing.T) {
+	overflowed := make(chan bool, 1)
+	o := newOutbox(Backpressure{Policy: disconnect, MaxMissed: 2}, func() {
+		overflowed <- true
+	})
+	fill(o)
+
+	o.push([]byte("new"))
+	select {
+	case <-overflowed:
+		t.Error("Overflowed after a single missed message")
+	case <-time.After(10 * time.Millisecond):
+	}
+
+	o.push([]byte("newer"))
+	select {
+	case <-overflowed:
+	case <-time.After(time.Second):
+		t.Error("Did not overflow after missing MaxMissed messages")
+	}
+}
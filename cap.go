@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"strconv"
+
+	redis "github.com/vmihailenco/redis/v2"
+)
+
+// name of the settings field holding the most requests a bin keeps
+const maxRequestsSetting = "maxRequests"
+
+// metaKey returns the redis key of the hash holding counters kept for the given bin, like how many
+// requests have been evicted from it.
+func metaKey(name string) string {
+	return "meta:" + name
+}
+
+// adds a request to a bin, evicting the oldest requests if the bin is over its cap and counting
+// them in the bin's metadata, which expires along with the bin. The placeholder from when the bin
+// was created is always first and is never evicted.
+var storeCappedScript = `
+redis.call('ZADD', KEYS[1], ARGV[1], ARGV[2])
+local cap = tonumber(ARGV[3])
+if cap <= 0 then
+	return 0
+end
+local over = redis.call('ZCARD', KEYS[1]) - 1 - cap
+if over <= 0 then
+	return 0
+end
+local evicted = redis.call('ZREMRANGEBYRANK', KEYS[1], 1, over)
+redis.call('HINCRBY', KEYS[2], 'evicted', evicted)
+local ttl = redis.call('PTTL', KEYS[1])
+if ttl > 0 then
+	redis.call('PEXPIRE', KEYS[2], ttl)
+else
+	redis.call('PERSIST', KEYS[2])
+end
+return evicted`
+
+// CapSettings holds the most requests a bin keeps, overriding the config's MaxBinRequests.
+type CapSettings struct {
+	MaxRequests int64 `json:"maxRequests"`
+}
+
+// validate checks that the cap is positive and, if the config caps every bin, no higher than that.
+func (cs CapSettings) validate() error {
+	if cs.MaxRequests < 0 {
+		return errors.New("maxRequests must not be negative.")
+	}
+	if config.MaxBinRequests > 0 && cs.MaxRequests > config.MaxBinRequests {
+		return fmt.Errorf("maxRequests must be at most %d.", config.MaxBinRequests)
+	}
+	return nil
+}
+
+// getMaxRequests returns the most requests the given bin keeps, or 0 if it keeps them all.
+func getMaxRequests(name string) (int64, error) {
+	var cs CapSettings
+	found, err := getBinSetting(name, maxRequestsSetting, &cs)
+	if err != nil {
+		return 0, err
+	}
+	if found && cs.MaxRequests > 0 {
+		return cs.MaxRequests, nil
+	}
+	return config.MaxBinRequests, nil
+}
+
+// addCapped adds member to the given bin with the given score, evicting the oldest requests if the
+// bin goes over its cap. It returns the number of requests evicted.
+func addCapped(name string, score int64, member string) (int64, error) {
+	maxRequests, err := getMaxRequests(name)
+	if err != nil {
+		return 0, err
+	}
+
+	if maxRequests <= 0 {
+		if res := client.ZAdd(name, redis.Z{Score: float64(score), Member: member}); res.Err() != nil {
+			log.Println("Failure to ZADD to", name, res.Err())
+			return 0, res.Err()
+		}
+		return 0, nil
+	}
+
+	res := client.Eval(storeCappedScript, []string{name, metaKey(name)}, []string{fmt.Sprint(score), member, fmt.Sprint(maxRequests)})
+	if res.Err() != nil {
+		log.Println("Failure to ZADD to", name, res.Err())
+		return 0, res.Err()
+	}
+	evicted, _ := res.Val().(int64)
+	return evicted, nil
+}
+
+// getEvicted returns the number of requests evicted from the given bin for going over its cap.
+func getEvicted(name string) (int64, error) {
+	res, err := client.HGet(metaKey(name), "evicted").Result()
+	if err == redis.Nil {
+		return 0, nil
+	}
+	if err != nil {
+		log.Println("Failure to HGET evicted for", name, err)
+		return 0, err
+	}
+	return strconv.ParseInt(res, 10, 64)
+}
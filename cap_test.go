@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestCapSettingsValidate(t *testing.T) {
+	max := config.MaxBinRequests
+	defer func() { config.MaxBinRequests = max }()
+	config.MaxBinRequests = 100
+
+	assert.Equal(t, nil, CapSettings{}.validate())
+	assert.Equal(t, nil, CapSettings{MaxRequests: 100}.validate())
+	assert.NotEqual(t, nil, CapSettings{MaxRequests: 101}.validate())
+	assert.NotEqual(t, nil, CapSettings{MaxRequests: -1}.validate())
+}
+
+func TestAddCapped(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+	if err := setBinSetting(binId, maxRequestsSetting, CapSettings{MaxRequests: 2}); err != nil {
+		t.Error(err)
+	}
+
+	for _, ts := range []int64{100, 200, 300} {
+		if _, err := storeRequest(binId, NewGeobinRequest(ts, map[string]string{}, []byte("{}"))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	// the oldest request was evicted, the placeholder was kept
+	history, err := getHistory(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(history))
+	assert.Equal(t, int64(200), history[0].Timestamp)
+	evicted, err := getEvicted(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), evicted)
+	exists, err := nameExists(binId)
+	assert.Equal(t, nil, err)
+	assert.T(t, exists)
+}
@@ -18,6 +18,53 @@ type Config struct {
 	RedisDB    int64
 	NameVals   string
 	NameLength int
+
+	// number of segments used when generating circle polygons for detected radii, at least 3,
+	// 0 disables circle generation
+	CircleSegments int
+
+	// seconds a bin lives by default, and the least and most it may ask for, 0 for 48 hours,
+	// 1 hour and 30 days
+	DefaultBinTTL int64
+	MinBinTTL     int64
+	MaxBinTTL     int64
+
+	// most requests each bin keeps, evicting the oldest, 0 to keep them all
+	MaxBinRequests int64
+
+	// requests allowed on each of the rate limited routes: "bin" (requests sent to a bin),
+	// "create", "accounts", "login", "history" and "bins", 1 per second for routes left out
+	RateLimits map[string]RateLimit
+
+	// requests each client IP is allowed on each of the rate limited routes, by route like
+	// RateLimits, routes left out aren't limited per client
+	ClientRateLimits map[string]RateLimit
+
+	// IPs or CIDRs of the proxies trusted to tell the client IP in X-Forwarded-For
+	TrustedProxies []string
+
+	// most bins created per hour by everyone but the admin, 0 for no limit
+	MaxBinsPerHour int64
+
+	// times a client IP may go over its ClientRateLimits within BanWindow seconds (60 if 0) before
+	// it is banned for BanDuration seconds, 0 to never ban
+	BanThreshold int64
+	BanWindow    int64
+	BanDuration  int64
+
+	// parts of the requests sent to every bin that are never stored, like credentials
+	Redaction RedactionRules
+
+	// let forwards and replays reach loopback, private and link-local addresses, which should only
+	// be done in development
+	AllowPrivateTargets bool
+
+	// leave the body of the upstream response out of replays, only returning its status and headers
+	OmitReplayResponseBodies bool
+
+	// token that grants admin access, like pinning bins, when sent with a request, empty to
+	// disable admin access
+	AdminKey string
 }
 
 // loadConfig reads configuration values from the config file
@@ -31,4 +78,23 @@ func loadConfig() {
 	if err != nil {
 		log.Fatal(err)
 	}
+	if config.CircleSegments < 0 || (config.CircleSegments > 0 && config.CircleSegments < minCircleSegments) {
+		log.Fatal("Invalid CircleSegments in config: must be 0 or at least ", minCircleSegments)
+	}
+	for route, rl := range config.RateLimits {
+		if err := rl.validate(); err != nil {
+			log.Fatal("Invalid RateLimits for ", route, " in config: ", err)
+		}
+	}
+	for route, rl := range config.ClientRateLimits {
+		if err := rl.validate(); err != nil {
+			log.Fatal("Invalid ClientRateLimits for ", route, " in config: ", err)
+		}
+	}
+	if err := validateProxies(config.TrustedProxies); err != nil {
+		log.Fatal("Invalid TrustedProxies in config: ", err)
+	}
+	if err := config.Redaction.validate(); err != nil {
+		log.Fatal("Invalid Redaction in config: ", err)
+	}
 }
@@ -0,0 +1,213 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// SocketMessage is a control message read from a websocket, e.g.:
+//
+// `{ "type": "filter", "filter": { "bbox": [-123, 45, -122, 46] } }`
+// `{ "type": "subscribe", "bin": "PF4C5zm67N" }`
+type SocketMessage struct {
+	Type string `json:"type"`
+	// the bin the message is about, only needed on multiplexed sockets
+	Bin string `json:"bin,omitempty"`
+	// the new StreamFilter for "filter" and "subscribe" messages, null to remove it
+	Filter json.RawMessage `json:"filter,omitempty"`
+	// the token of a private bin, for "subscribe" messages
+	Token string `json:"token,omitempty"`
+}
+
+// SocketNotice is a control message written to a websocket. Control messages always have a
+// "type", which requests never do, so clients can tell the two apart.
+type SocketNotice struct {
+	Type  string `json:"type"`
+	Bin   string `json:"bin,omitempty"`
+	Error string `json:"error,omitempty"`
+	// number of messages dropped, for "missed" notices
+	Count int `json:"count,omitempty"`
+}
+
+// payloadType returns the type of the control message encoded in the given JSON payload, or an
+// empty string if it isn't a control message.
+func payloadType(payload []byte) string {
+	var msg struct {
+		Type string `json:"type"`
+	}
+	json.Unmarshal(payload, &msg)
+	return msg.Type
+}
+
+// SocketList is written to a multiplexed websocket in reply to a "list" message.
+type SocketList struct {
+	Type string   `json:"type"`
+	Bins []string `json:"bins"`
+}
+
+// socketControl handles the control messages read from a socket. If binName is empty, the socket
+// is multiplexed and may subscribe to any number of bins.
+type socketControl struct {
+	binName    string
+	socketUUID string
+	socket     Socket
+	// the token sent when the socket was opened, used for "subscribe" messages without one
+	token string
+
+	// closed once the socket is ready to be written to
+	ready chan bool
+}
+
+// newSocketControl returns a socketControl for the socket with the given UUID, which is
+// subscribed to the given bin, or multiplexed if binName is empty.
+func newSocketControl(binName, socketUUID string) *socketControl {
+	return &socketControl{
+		binName:    binName,
+		socketUUID: socketUUID,
+		ready:      make(chan bool),
+	}
+}
+
+// start hands the socket over once it is set up. Messages read from the socket before then wait.
+func (c *socketControl) start(s Socket) {
+	c.socket = s
+	close(c.ready)
+}
+
+// onRead handles a message read from the socket, writing any reply back to it.
+func (c *socketControl) onRead(mt int, message []byte) {
+	<-c.ready
+
+	reply := c.handle(message)
+	if reply == nil {
+		return
+	}
+
+	payload, err := json.Marshal(reply)
+	if err != nil {
+		log.Println("Error marshalling socket notice:", err)
+		return
+	}
+	c.socket.Write(payload)
+}
+
+// handle acts on a control message from the socket. It returns a reply to send back, if any.
+func (c *socketControl) handle(message []byte) interface{} {
+	var msg SocketMessage
+	if err := json.Unmarshal(message, &msg); err != nil {
+		return &SocketNotice{Type: "error", Error: "Messages must be JSON objects."}
+	}
+
+	bin := msg.Bin
+	if c.binName != "" {
+		bin = c.binName
+	}
+
+	switch msg.Type {
+	case "filter":
+		f, err := msg.filter()
+		if err != nil {
+			return &SocketNotice{Type: "error", Bin: bin, Error: err.Error()}
+		}
+		if err := socketMap.SetFilter(bin, c.socketUUID, f); err != nil {
+			return &SocketNotice{Type: "error", Bin: bin, Error: err.Error()}
+		}
+		return nil
+	case "subscribe", "unsubscribe", "list":
+		if c.binName != "" {
+			return &SocketNotice{Type: "error", Error: fmt.Sprintf("%q is only available on /api/1/ws.", msg.Type)}
+		}
+	default:
+		return &SocketNotice{Type: "error", Error: fmt.Sprintf("Unknown message type %q.", msg.Type)}
+	}
+
+	switch msg.Type {
+	case "subscribe":
+		f, err := msg.filter()
+		if err != nil {
+			return &SocketNotice{Type: "error", Bin: bin, Error: err.Error()}
+		}
+		token := msg.Token
+		if token == "" {
+			token = c.token
+		}
+		if err := subscribeSocket(bin, c.socketUUID, token, &envelopeSocket{Socket: c.socket, bin: bin}, f); err != nil {
+			return &SocketNotice{Type: "error", Bin: bin, Error: err.Error()}
+		}
+		return &SocketNotice{Type: "subscribed", Bin: bin}
+	case "unsubscribe":
+		if err := socketMap.Delete(bin, c.socketUUID); err != nil {
+			return &SocketNotice{Type: "error", Bin: bin, Error: err.Error()}
+		}
+		return &SocketNotice{Type: "unsubscribed", Bin: bin}
+	default:
+		return &SocketList{Type: "list", Bins: socketMap.Bins(c.socketUUID)}
+	}
+}
+
+// filter returns the StreamFilter given in the message, or nil if there isn't one.
+func (msg SocketMessage) filter() (*StreamFilter, error) {
+	if len(msg.Filter) == 0 || string(msg.Filter) == "null" {
+		return nil, nil
+	}
+	return parseStreamFilter(msg.Filter)
+}
+
+// subscribeSocket starts sending the requests to the given bin that match f to s, if the token
+// grants access to the bin.
+func subscribeSocket(binName, socketUUID, token string, s Socket, f *StreamFilter) error {
+	exists, err := nameExists(binName)
+	if err != nil {
+		return fmt.Errorf("Could not subscribe to %q.", binName)
+	}
+	if !exists {
+		return fmt.Errorf("There is no bin %q.", binName)
+	}
+
+	ok, err := checkAccess(binName, token, viewAccess)
+	if err != nil {
+		return fmt.Errorf("Could not subscribe to %q.", binName)
+	}
+	if !ok {
+		return fmt.Errorf("The token does not grant access to %q.", binName)
+	}
+
+	link, err := getLink(binName, token)
+	if err != nil {
+		return fmt.Errorf("Could not subscribe to %q.", binName)
+	}
+	if link != nil {
+		// only this bin is dropped when the link ends, not the whole socket
+		s = &linkSocket{Socket: s, link: link, end: func() {
+			socketMap.Delete(binName, socketUUID)
+		}}
+	}
+
+	if err := pubsub.Subscribe(binName); err != nil {
+		log.Println("Failure to SUBSCRIBE to", binName, err)
+		return fmt.Errorf("Could not subscribe to %q.", binName)
+	}
+
+	socketMap.Add(binName, socketUUID, s, f)
+	return nil
+}
+
+// envelopeSocket wraps a multiplexed Socket, wrapping each message written to it in an object
+// naming the bin it came from, e.g. `{ "bin": "PF4C5zm67N", "data": { ... } }`.
+type envelopeSocket struct {
+	Socket
+	bin string
+}
+
+func (es *envelopeSocket) Write(payload []byte) {
+	envelope, err := json.Marshal(struct {
+		Bin  string          `json:"bin"`
+		Data json.RawMessage `json:"data"`
+	}{es.bin, payload})
+	if err != nil {
+		log.Println("Error marshalling envelope for", es.bin, err)
+		return
+	}
+	es.Socket.Write(envelope)
+}
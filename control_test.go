@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSocketControlFilter(t *testing.T) {
+	sm := socketMap
+	socketMap = NewSocketMap(nil)
+	defer func() {
+		socketMap = sm
+	}()
+
+	ms := &MockSocket{name: "mock_socket"}
+	socketMap.Add("bin_name", "socket_uuid", ms, nil)
+	c := newSocketControl("bin_name", "socket_uuid")
+	c.start(ms)
+
+	// filters can be set and removed
+	assert.Equal(t, nil, c.handle([]byte(`{"type": "filter", "filter": {"hasGeo": true}}`)))
+	assert.Equal(t, nil, c.handle([]byte(`{"type": "filter", "filter": null}`)))
+
+	// anything else gets an error
+	for _, msg := range []string{
+		`not json`,
+		`{"type": "unknown"}`,
+		`{"type": "filter", "filter": {"bbox": [1]}}`,
+		`{"type": "subscribe", "bin": "other_bin"}`,
+	} {
+		notice, ok := c.handle([]byte(msg)).(*SocketNotice)
+		assert.T(t, ok, msg)
+		assert.Equal(t, "error", notice.Type, msg)
+	}
+
+	// which is written back to the socket
+	c.onRead(1, []byte(`{"type": "unknown"}`))
+	assert.Equal(t, []string{`{"type":"error","error":"Unknown message type \"unknown\"."}`}, ms.getWritten())
+}
+
+func TestSocketControlMultiplexed(t *testing.T) {
+	sm := socketMap
+	socketMap = NewSocketMap(nil)
+	defer func() {
+		socketMap = sm
+	}()
+
+	ms := &MockSocket{name: "mock_socket"}
+	c := newSocketControl("", "socket_uuid")
+	c.start(ms)
+
+	assert.Equal(t, &SocketList{Type: "list", Bins: []string{}}, c.handle([]byte(`{"type": "list"}`)))
+
+	// subscriptions are added to the socket map wrapped in an envelope
+	socketMap.Add("bin_a", "socket_uuid", &envelopeSocket{Socket: ms, bin: "bin_a"}, nil)
+	socketMap.Add("bin_b", "socket_uuid", &envelopeSocket{Socket: ms, bin: "bin_b"}, nil)
+	assert.Equal(t, &SocketList{Type: "list", Bins: []string{"bin_a", "bin_b"}}, c.handle([]byte(`{"type": "list"}`)))
+
+	assert.Equal(t, &SocketNotice{Type: "unsubscribed", Bin: "bin_a"}, c.handle([]byte(`{"type": "unsubscribe", "bin": "bin_a"}`)))
+	assert.Equal(t, &SocketList{Type: "list", Bins: []string{"bin_b"}}, c.handle([]byte(`{"type": "list"}`)))
+
+	notice := c.handle([]byte(`{"type": "unsubscribe", "bin": "bin_a"}`)).(*SocketNotice)
+	assert.Equal(t, "error", notice.Type)
+
+	// filters apply to a single bin
+	assert.Equal(t, nil, c.handle([]byte(`{"type": "filter", "bin": "bin_b", "filter": {"hasGeo": true}}`)))
+	notice = c.handle([]byte(`{"type": "filter", "bin": "bin_a", "filter": {"hasGeo": true}}`)).(*SocketNotice)
+	assert.Equal(t, "error", notice.Type)
+}
+
+func TestEnvelopeSocket(t *testing.T) {
+	ms := &MockSocket{name: "mock_socket"}
+	es := &envelopeSocket{Socket: ms, bin: "bin_name"}
+	es.Write([]byte(`{"id":"1-1"}`))
+
+	assert.Equal(t, []string{`{"bin":"bin_name","data":{"id":"1-1"}}`}, ms.getWritten())
+	assert.Equal(t, "mock_socket", es.GetName())
+
+	var envelope struct {
+		Bin  string
+		Data map[string]interface{}
+	}
+	assert.Equal(t, nil, json.Unmarshal([]byte(ms.getWritten()[0]), &envelope))
+	assert.Equal(t, "1-1", envelope.Data["id"])
+}
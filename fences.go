@@ -0,0 +1,186 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+
+	redis "github.com/vmihailenco/redis/v2"
+)
+
+// name of the settings field holding a bin's fences
+const fencesSetting = "fences"
+
+// Fence event types
+const (
+	fenceEnter  = "enter"
+	fenceExit   = "exit"
+	fenceInside = "inside"
+)
+
+// Fence is a named area that incoming geo data is tested against. The area is described the
+// same way as a SpatialQuery: a bbox, a point and radius, or a polygon.
+type Fence struct {
+	Name string `json:"name"`
+	SpatialQuery
+}
+
+// FenceSettings holds the fences defined for a bin.
+type FenceSettings struct {
+	// Dot separated key path to the device ID in request bodies, looked up the same way as
+	// TrackOptions.DeviceKey. Enter and exit events are tracked separately for each device.
+	DeviceKey string  `json:"deviceKey"`
+	Fences    []Fence `json:"fences"`
+}
+
+// FenceEvent describes a device entering, leaving or staying inside of a fence.
+type FenceEvent struct {
+	Type   string `json:"type"`
+	Fence  string `json:"fence"`
+	Device string `json:"device"`
+}
+
+// validate checks that every fence has a unique name and a valid area.
+func (fs FenceSettings) validate() error {
+	names := make(map[string]bool)
+	for _, f := range fs.Fences {
+		if f.Name == "" {
+			return errors.New("Every fence needs a name.")
+		}
+		if names[f.Name] {
+			return fmt.Errorf("Fence names must be unique, found %q more than once.", f.Name)
+		}
+		names[f.Name] = true
+
+		if _, err := f.area(); err != nil {
+			return fmt.Errorf("Fence %q is invalid: %v", f.Name, err)
+		}
+	}
+	return nil
+}
+
+// fenceStateKey returns the redis key of the hash holding whether or not each device was last
+// seen inside of each fence of the given bin.
+func fenceStateKey(name string) string {
+	return "fence-state:" + name
+}
+
+// fenceStateField returns the field of the fence state hash for the given fence and device.
+func fenceStateField(fence, device string) string {
+	return fmt.Sprintf("%q:%q", fence, device)
+}
+
+// swaps the value of a hash field and returns the old value in a single step, so that concurrent
+// requests for the same device can't both see the same transition
+var hashSwapScript = `
+local old = redis.call('HGET', KEYS[1], ARGV[1])
+redis.call('HSET', KEYS[1], ARGV[1], ARGV[2])
+return old`
+
+// getFences returns the fences defined for the given bin.
+func getFences(name string) (FenceSettings, error) {
+	var fs FenceSettings
+	_, err := getBinSetting(name, fencesSetting, &fs)
+	return fs, err
+}
+
+// setFences replaces the fences defined for the given bin and forgets which devices were inside
+// of the old ones.
+func setFences(name string, fs FenceSettings) error {
+	if err := setBinSetting(name, fencesSetting, fs); err != nil {
+		return err
+	}
+
+	if res := client.Del(fenceStateKey(name)); res.Err() != nil {
+		log.Println("Failure to DEL", fenceStateKey(name), res.Err())
+		return res.Err()
+	}
+	return nil
+}
+
+// evaluateFences tests the geo data found in gr against the fences defined for the given bin.
+// It returns a new GeobinRequest for each event that occurred, holding the event and the geo data
+// of the device that triggered it.
+func evaluateFences(name string, gr *GeobinRequest) ([]*GeobinRequest, error) {
+	if len(gr.Geo) == 0 {
+		return nil, nil
+	}
+
+	fs, err := getFences(name)
+	if err != nil || len(fs.Fences) == 0 {
+		return nil, err
+	}
+
+	// group the request's geo data by device
+	var body interface{}
+	keyPath := splitKeyPath(fs.DeviceKey)
+	if keyPath != nil {
+		json.Unmarshal([]byte(gr.Body), &body)
+	}
+	devices := make([]string, 0)
+	geos := make(map[string][]Geo)
+	for _, g := range gr.Geo {
+		device := deviceID(body, g.Path, keyPath)
+		if _, ok := geos[device]; !ok {
+			devices = append(devices, device)
+		}
+		geos[device] = append(geos[device], g)
+	}
+
+	events := make([]*GeobinRequest, 0)
+	for _, f := range fs.Fences {
+		area, err := f.area()
+		if err != nil {
+			log.Println("Skipping invalid fence", f.Name, "for", name, err)
+			continue
+		}
+
+		for _, device := range devices {
+			matched := make([]Geo, 0)
+			for _, g := range geos[device] {
+				if f.matches(toShape(g.Geo), area) {
+					matched = append(matched, g)
+				}
+			}
+			inside := len(matched) > 0
+
+			state := "0"
+			if inside {
+				state = "1"
+			}
+			res := client.Eval(hashSwapScript, []string{fenceStateKey(name)}, []string{fenceStateField(f.Name, device), state})
+			if res.Err() != nil && res.Err() != redis.Nil {
+				log.Println("Failure to update fence state for", name, res.Err())
+				return events, res.Err()
+			}
+			wasInside := res.Val() == "1"
+
+			ev := FenceEvent{Fence: f.Name, Device: device}
+			switch {
+			case inside && wasInside:
+				ev.Type = fenceInside
+			case inside:
+				ev.Type = fenceEnter
+			case wasInside:
+				ev.Type = fenceExit
+				matched = geos[device]
+			default:
+				continue
+			}
+
+			events = append(events, &GeobinRequest{
+				Timestamp: gr.Timestamp,
+				Headers:   map[string]string{},
+				Geo:       matched,
+				Event:     &ev,
+			})
+		}
+	}
+
+	if err := expireWithBin(name, fenceStateKey(name)); err != nil {
+		return events, err
+	}
+
+	return events, nil
+}
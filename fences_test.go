@@ -0,0 +1,79 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestFenceSettingsValidate(t *testing.T) {
+	circle := SpatialQuery{Point: []float64{-122.5, 45.5}, Radius: 100}
+
+	valid := FenceSettings{Fences: []Fence{
+		{Name: "a", SpatialQuery: circle},
+		{Name: "b", SpatialQuery: SpatialQuery{BBox: []float64{0, 0, 1, 1}}},
+	}}
+	assert.Equal(t, nil, valid.validate())
+	assert.Equal(t, nil, FenceSettings{}.validate())
+
+	for _, fs := range []FenceSettings{
+		{Fences: []Fence{{SpatialQuery: circle}}},
+		{Fences: []Fence{{Name: "a", SpatialQuery: circle}, {Name: "a", SpatialQuery: circle}}},
+		{Fences: []Fence{{Name: "a"}}},
+	} {
+		assert.NotEqual(t, nil, fs.validate())
+	}
+}
+
+func fenceEventTypes(events []*GeobinRequest) []string {
+	types := make([]string, len(events))
+	for i, ev := range events {
+		types[i] = ev.Event.Fence + " " + ev.Event.Device + " " + ev.Event.Type
+	}
+	return types
+}
+
+func TestEvaluateFences(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	err = setFences(binId, FenceSettings{
+		DeviceKey: "id",
+		Fences: []Fence{
+			{Name: "home", SpatialQuery: SpatialQuery{Point: []float64{-122.5, 45.5}, Radius: 100}},
+		},
+	})
+	assert.Equal(t, nil, err)
+
+	evaluate := func(body string) []string {
+		events, err := evaluateFences(binId, NewGeobinRequest(0, nil, []byte(body)))
+		assert.Equal(t, nil, err)
+		return fenceEventTypes(events)
+	}
+
+	assert.Equal(t, []string{}, evaluate(`{"id": "a", "lat": 10, "lng": 10}`))
+	assert.Equal(t, []string{`home a enter`}, evaluate(`{"id": "a", "lat": 45.5, "lng": -122.5}`))
+	assert.Equal(t, []string{`home a inside`}, evaluate(`{"id": "a", "lat": 45.5001, "lng": -122.5}`))
+
+	// other devices are tracked separately
+	assert.Equal(t, []string{`home b enter`}, evaluate(`{"id": "b", "lat": 45.5, "lng": -122.5}`))
+
+	assert.Equal(t, []string{`home a exit`}, evaluate(`{"id": "a", "lat": 10, "lng": 10}`))
+	assert.Equal(t, []string{}, evaluate(`{"id": "a", "lat": 10, "lng": 10}`))
+
+	// requests without geo data don't change anything
+	assert.Equal(t, []string{}, evaluate(`{"id": "b"}`))
+	assert.Equal(t, []string{`home b inside`}, evaluate(`{"id": "b", "lat": 45.5, "lng": -122.5}`))
+
+	// replacing the fences forgets who was inside of them
+	err = setFences(binId, FenceSettings{
+		DeviceKey: "id",
+		Fences: []Fence{
+			{Name: "home", SpatialQuery: SpatialQuery{Point: []float64{-122.5, 45.5}, Radius: 100}},
+		},
+	})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{`home b enter`}, evaluate(`{"id": "b", "lat": 45.5, "lng": -122.5}`))
+}
@@ -0,0 +1,262 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// StreamFilter picks out the requests sent to a socket subscribed to a bin. Every given condition
+// must match for a request to be sent.
+type StreamFilter struct {
+	// [minLng, minLat, maxLng, maxLat] that some of the request's geo data must fall within
+	BBox []float64 `json:"bbox,omitempty"`
+	// GeoJSON geometry types, like "Point" or "Polygon", at least one of which must be found
+	Types []string `json:"types,omitempty"`
+	// only send requests that had geo data
+	HasGeo bool `json:"hasGeo,omitempty"`
+	// headers that must have exactly the given values
+	Headers map[string]string `json:"headers,omitempty"`
+	// a JSONPath predicate on the request body, like "$.vehicle.status == 'active'"
+	Body string `json:"body,omitempty"`
+
+	area      shape
+	predicate *bodyPredicate
+}
+
+// parseStreamFilter decodes and validates the JSON filter in data.
+func parseStreamFilter(data []byte) (*StreamFilter, error) {
+	var f StreamFilter
+	if err := json.Unmarshal(data, &f); err != nil {
+		return nil, errors.New("filter must be a JSON object.")
+	}
+	if err := f.compile(); err != nil {
+		return nil, err
+	}
+	return &f, nil
+}
+
+// compile checks the filter and prepares it for matching.
+func (f *StreamFilter) compile() error {
+	if f.BBox != nil {
+		if len(f.BBox) != 4 || f.BBox[0] > f.BBox[2] || f.BBox[1] > f.BBox[3] {
+			return errors.New("bbox must be [minLng, minLat, maxLng, maxLat].")
+		}
+		f.area = rectShape(rect{f.BBox[0], f.BBox[1], f.BBox[2], f.BBox[3]})
+	}
+
+	if f.Body != "" {
+		p, err := parseBodyPredicate(f.Body)
+		if err != nil {
+			return err
+		}
+		f.predicate = p
+	}
+
+	return nil
+}
+
+// matches returns true if gr meets every condition of the filter.
+func (f *StreamFilter) matches(gr *GeobinRequest) bool {
+	if f.HasGeo && len(gr.Geo) == 0 {
+		return false
+	}
+
+	if f.BBox != nil {
+		found := false
+		for _, g := range gr.Geo {
+			if toShape(g.Geo).intersects(f.area) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	if len(f.Types) > 0 {
+		found := false
+		for _, g := range gr.Geo {
+			for _, t := range f.Types {
+				if geometryType(g.Geo) == t {
+					found = true
+				}
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+
+	for k, v := range f.Headers {
+		if gr.Headers[http.CanonicalHeaderKey(k)] != v {
+			return false
+		}
+	}
+
+	if f.predicate != nil {
+		var body interface{}
+		if err := json.Unmarshal([]byte(gr.Body), &body); err != nil {
+			return false
+		}
+		return f.predicate.matches(body)
+	}
+
+	return true
+}
+
+// geometryType returns the type of the given GeoJSON geometry, or of the geometry of a Feature.
+func geometryType(geo map[string]interface{}) string {
+	if geo["type"] == "Feature" {
+		if g, ok := geo["geometry"].(map[string]interface{}); ok {
+			return geometryType(g)
+		}
+		return ""
+	}
+	t, _ := geo["type"].(string)
+	return t
+}
+
+// bodyPredicate is a test of the value at a path in a JSON document. Without an operator, it
+// tests that the value exists and is not null or false.
+type bodyPredicate struct {
+	path  []string
+	op    string
+	value interface{}
+}
+
+// comparison operators, longest first so that "<=" isn't read as "<"
+var predicateOps = []string{"==", "!=", "<=", ">=", "<", ">"}
+
+// parseBodyPredicate parses a JSONPath predicate of the form `$.path[0].to['value'] op literal`,
+// where the operator and literal are optional. Literals are JSON values, or strings in single
+// quotes.
+func parseBodyPredicate(expr string) (*bodyPredicate, error) {
+	expr = strings.TrimSpace(expr)
+	if !strings.HasPrefix(expr, "$") {
+		return nil, fmt.Errorf("%q must start with $.", expr)
+	}
+
+	path, rest, err := parseJSONPath(expr[1:])
+	if err != nil {
+		return nil, fmt.Errorf("%q is not a valid JSONPath: %v", expr, err)
+	}
+
+	p := &bodyPredicate{path: path}
+	rest = strings.TrimSpace(rest)
+	if rest == "" {
+		return p, nil
+	}
+
+	for _, op := range predicateOps {
+		if strings.HasPrefix(rest, op) {
+			p.op = op
+			break
+		}
+	}
+	if p.op == "" {
+		return nil, fmt.Errorf("%q has an unknown operator.", expr)
+	}
+
+	literal := strings.TrimSpace(rest[len(p.op):])
+	if len(literal) >= 2 && literal[0] == '\'' && literal[len(literal)-1] == '\'' {
+		p.value = literal[1 : len(literal)-1]
+	} else if err := json.Unmarshal([]byte(literal), &p.value); err != nil {
+		return nil, fmt.Errorf("%q has an invalid value: %s", expr, literal)
+	}
+
+	return p, nil
+}
+
+// parseJSONPath reads `.key`, `[index]` and `['key']` steps from the start of s. It returns the
+// keys, suitable for valueAtPath, and whatever follows the path.
+func parseJSONPath(s string) ([]string, string, error) {
+	path := make([]string, 0)
+	for len(s) > 0 {
+		switch s[0] {
+		case '.':
+			end := strings.IndexAny(s[1:], ".[ =!<>")
+			if end == -1 {
+				end = len(s) - 1
+			}
+			if end == 0 {
+				return nil, "", errors.New("empty key")
+			}
+			path = append(path, s[1:end+1])
+			s = s[end+1:]
+		case '[':
+			end := strings.Index(s, "]")
+			if end == -1 {
+				return nil, "", errors.New("unclosed [")
+			}
+			key := strings.TrimSpace(s[1:end])
+			if len(key) >= 2 && (key[0] == '\'' || key[0] == '"') && key[len(key)-1] == key[0] {
+				key = key[1 : len(key)-1]
+			} else if _, err := strconv.Atoi(key); err != nil {
+				return nil, "", fmt.Errorf("%q is not an index or a quoted key", key)
+			}
+			path = append(path, key)
+			s = s[end+1:]
+		default:
+			return path, s, nil
+		}
+	}
+	return path, "", nil
+}
+
+// matches returns true if the predicate holds for the given JSON document.
+func (p *bodyPredicate) matches(js interface{}) bool {
+	v, ok := valueAtPath(js, p.path)
+	if !ok {
+		return false
+	}
+
+	switch p.op {
+	case "":
+		return v != nil && v != false
+	case "==":
+		return reflect.DeepEqual(v, p.value)
+	case "!=":
+		return !reflect.DeepEqual(v, p.value)
+	}
+
+	// the rest compare numbers with numbers or strings with strings
+	var cmp int
+	switch a := v.(type) {
+	case float64:
+		b, ok := p.value.(float64)
+		if !ok {
+			return false
+		}
+		switch {
+		case a < b:
+			cmp = -1
+		case a > b:
+			cmp = 1
+		}
+	case string:
+		b, ok := p.value.(string)
+		if !ok {
+			return false
+		}
+		cmp = strings.Compare(a, b)
+	default:
+		return false
+	}
+
+	switch p.op {
+	case "<":
+		return cmp < 0
+	case ">":
+		return cmp > 0
+	case "<=":
+		return cmp <= 0
+	default:
+		return cmp >= 0
+	}
+}
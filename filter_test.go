@@ -0,0 +1,90 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestParseStreamFilter(t *testing.T) {
+	f, err := parseStreamFilter([]byte(`{"bbox": [-11, 9, -9, 11], "body": "$.status == 'active'"}`))
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, (*bodyPredicate)(nil), f.predicate)
+
+	invalid := []string{
+		`[]`,
+		`{"bbox": [1, 2, 3]}`,
+		`{"bbox": [3, 2, 1, 0]}`,
+		`{"body": "status"}`,
+		`{"body": "$.status ~ 1"}`,
+		`{"body": "$.status == active"}`,
+		`{"body": "$.items[x]"}`,
+	}
+	for _, js := range invalid {
+		_, err := parseStreamFilter([]byte(js))
+		assert.NotEqual(t, nil, err, js)
+	}
+}
+
+func TestParseBodyPredicate(t *testing.T) {
+	tests := []struct {
+		expr string
+		path []string
+		op   string
+		val  interface{}
+	}{
+		{"$.status", []string{"status"}, "", nil},
+		{"$.vehicle.id == 'abc'", []string{"vehicle", "id"}, "==", "abc"},
+		{`$.vehicle["id"] != "abc"`, []string{"vehicle", "id"}, "!=", "abc"},
+		{"$.points[0].speed>=10.5", []string{"points", "0", "speed"}, ">=", 10.5},
+		{"$.ok == true", []string{"ok"}, "==", true},
+		{"$.gone == null", []string{"gone"}, "==", nil},
+	}
+
+	for _, test := range tests {
+		p, err := parseBodyPredicate(test.expr)
+		assert.Equal(t, nil, err, test.expr)
+		assert.Equal(t, test.path, p.path, test.expr)
+		assert.Equal(t, test.op, p.op, test.expr)
+		assert.Equal(t, test.val, p.value, test.expr)
+	}
+}
+
+func TestStreamFilterMatches(t *testing.T) {
+	gr := NewGeobinRequest(0, map[string]string{"X-Device": "tracker"}, []byte(`{
+		"status": "active",
+		"speed": 12,
+		"location": {"lat": 10, "lng": -10}
+	}`))
+	empty := NewGeobinRequest(0, map[string]string{}, []byte(`{"status": "idle"}`))
+
+	tests := []struct {
+		filter   string
+		gr       *GeobinRequest
+		expected bool
+	}{
+		{`{}`, gr, true},
+		{`{}`, empty, true},
+		{`{"hasGeo": true}`, gr, true},
+		{`{"hasGeo": true}`, empty, false},
+		{`{"bbox": [-11, 9, -9, 11]}`, gr, true},
+		{`{"bbox": [0, 0, 1, 1]}`, gr, false},
+		{`{"types": ["Point"]}`, gr, true},
+		{`{"types": ["Polygon"]}`, gr, false},
+		{`{"headers": {"x-device": "tracker"}}`, gr, true},
+		{`{"headers": {"X-Device": "phone"}}`, gr, false},
+		{`{"body": "$.status == 'active'"}`, gr, true},
+		{`{"body": "$.status == 'active'"}`, empty, false},
+		{`{"body": "$.speed > 10"}`, gr, true},
+		{`{"body": "$.speed < 10"}`, gr, false},
+		{`{"body": "$.speed"}`, gr, true},
+		{`{"body": "$.speed"}`, empty, false},
+		{`{"body": "$.location.lat <= 10", "hasGeo": true}`, gr, true},
+	}
+
+	for _, test := range tests {
+		f, err := parseStreamFilter([]byte(test.filter))
+		assert.Equal(t, nil, err, test.filter)
+		assert.Equal(t, test.expected, f.matches(test.gr), test.filter)
+	}
+}
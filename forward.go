@@ -0,0 +1,282 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// name of the settings field holding a bin's forward targets
+const forwardsSetting = "forwards"
+
+// header sent with every forwarded request, so that a bin forwarding to itself, or to another bin
+// forwarding back to it, doesn't forward the same request forever
+const forwardedHeader = "X-Geobin-Forwarded"
+
+const (
+	// number of times a failed forward is retried when a bin doesn't say otherwise
+	defaultForwardRetries = 3
+	// most retries a bin may ask for
+	maxForwardRetries = 10
+	// time allowed for each attempt
+	forwardTimeout = 10 * time.Second
+)
+
+// delay before the first retry of a forward, doubled for each one after
+var forwardBackoff = 500 * time.Millisecond
+
+// client used to make outbound requests. It won't connect to private addresses, whatever the
+// target's name resolves to by the time it connects.
+var forwardClient = &http.Client{
+	Timeout: forwardTimeout,
+	Transport: &http.Transport{
+		DialContext:         (&net.Dialer{Timeout: forwardTimeout, Control: checkDialAddress}).DialContext,
+		TLSHandshakeTimeout: forwardTimeout,
+	},
+}
+
+var errPrivateTarget = errors.New("Requests can't be sent to loopback, private or link-local addresses.")
+
+// headers that only apply to a single connection and so are never passed along
+var hopByHopHeaders = map[string]bool{
+	"Connection":          true,
+	"Content-Length":      true,
+	"Keep-Alive":          true,
+	"Proxy-Authenticate":  true,
+	"Proxy-Authorization": true,
+	"Te":                  true,
+	"Trailer":             true,
+	"Transfer-Encoding":   true,
+	"Upgrade":             true,
+}
+
+// ForwardTarget is a URL that requests received by a bin are passed along to.
+type ForwardTarget struct {
+	URL string `json:"url"`
+	// headers added to, or replacing those of, each forwarded request
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// ForwardSettings holds the forward targets defined for a bin.
+type ForwardSettings struct {
+	Targets []ForwardTarget `json:"targets"`
+	// number of times to retry a forward that fails or gets a 5xx or 429 response, defaults to 3
+	Retries *int `json:"retries,omitempty"`
+}
+
+// ForwardResult records the outcome of passing a request along to a single URL.
+type ForwardResult struct {
+	URL      string  `json:"url"`
+	Status   int     `json:"status,omitempty"` // the status of the last response, if any
+	Latency  float64 `json:"latency"`          // of the last attempt, in milliseconds
+	Attempts int     `json:"attempts"`
+	Error    string  `json:"error,omitempty"`
+}
+
+// validate checks that every target is an absolute http(s) URL and the retries are in range.
+func (fs ForwardSettings) validate() error {
+	for _, t := range fs.Targets {
+		if err := validateTargetURL(t.URL); err != nil {
+			return err
+		}
+	}
+	if fs.Retries != nil && (*fs.Retries < 0 || *fs.Retries > maxForwardRetries) {
+		return fmt.Errorf("retries must be between 0 and %d.", maxForwardRetries)
+	}
+	return nil
+}
+
+// retries returns the number of times a failed forward should be retried.
+func (fs ForwardSettings) retries() int {
+	if fs.Retries == nil {
+		return defaultForwardRetries
+	}
+	return *fs.Retries
+}
+
+// validateTargetURL checks that u is an absolute http(s) URL whose host isn't the config's Host,
+// and isn't, or doesn't resolve to, a private address. Hosts that can't be resolved yet are left
+// for checkDialAddress.
+func validateTargetURL(u string) error {
+	parsed, err := url.Parse(u)
+	if err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("%q is not a valid http(s) URL.", u)
+	}
+	host := parsed.Hostname()
+	if config.Host != "" && strings.EqualFold(host, config.Host) {
+		return fmt.Errorf("%q is this server.", u)
+	}
+	if config.AllowPrivateTargets {
+		return nil
+	}
+
+	ips := []net.IP{net.ParseIP(host)}
+	if ips[0] == nil {
+		if ips, err = net.LookupIP(host); err != nil {
+			debugLog("Could not resolve", host, err)
+		}
+	}
+	for _, ip := range ips {
+		if isPrivateIP(ip) {
+			return fmt.Errorf("%q is a loopback, private or link-local address.", u)
+		}
+	}
+	return nil
+}
+
+// isPrivateIP returns true if ip is a loopback, private, link-local, multicast or unspecified
+// address, which outbound requests must not reach.
+func isPrivateIP(ip net.IP) bool {
+	return ip.IsLoopback() || ip.IsPrivate() || ip.IsLinkLocalUnicast() || ip.IsLinkLocalMulticast() ||
+		ip.IsInterfaceLocalMulticast() || ip.IsMulticast() || ip.IsUnspecified()
+}
+
+// checkDialAddress stops forwardClient from connecting to a private address, unless the config
+// allows it. It is checked once the target's name has been resolved, so that a name can't be made
+// to resolve to a private address after the target was validated.
+func checkDialAddress(network, address string, c syscall.RawConn) error {
+	if config.AllowPrivateTargets {
+		return nil
+	}
+	host, _, err := net.SplitHostPort(address)
+	if err != nil {
+		return err
+	}
+	if ip := net.ParseIP(host); ip == nil || isPrivateIP(ip) {
+		return errPrivateTarget
+	}
+	return nil
+}
+
+// getForwards returns the forward targets defined for the given bin.
+func getForwards(name string) (ForwardSettings, error) {
+	var fs ForwardSettings
+	_, err := getBinSetting(name, forwardsSetting, &fs)
+	return fs, err
+}
+
+// forwardRequest passes raw, the request as it was received, along to each of the bin's forward
+// targets at the same time, retrying failures with an exponential backoff. Once every target is
+// done, the results are recorded on gr, its stored copy, which was stored as `member`. It should
+// be called in its own goroutine.
+func forwardRequest(name string, gr, raw *GeobinRequest, member string) {
+	fs, err := getForwards(name)
+	if err != nil || len(fs.Targets) == 0 {
+		return
+	}
+
+	results := make([]ForwardResult, len(fs.Targets))
+	var wg sync.WaitGroup
+	for i, t := range fs.Targets {
+		wg.Add(1)
+		go func(i int, t ForwardTarget) {
+			defer wg.Done()
+			results[i] = forwardWithRetries(raw, t, fs.retries())
+		}(i, t)
+	}
+	wg.Wait()
+
+	gr.Forwards = results
+	if err := replaceRequest(name, member, gr); err != nil {
+		log.Println("Failure to record forward results for", name, err)
+	}
+}
+
+// forwardWithRetries sends gr to the target, retrying up to `retries` times when the request
+// fails or gets a 5xx or 429 response.
+func forwardWithRetries(gr *GeobinRequest, t ForwardTarget, retries int) ForwardResult {
+	result := ForwardResult{URL: t.URL}
+	backoff := forwardBackoff
+	headers := map[string]string{forwardedHeader: "1"}
+	for k, v := range t.Headers {
+		headers[k] = v
+	}
+	for {
+		result.Attempts++
+
+		start := time.Now()
+		resp, err := sendRequest(gr, t.URL, headers)
+		result.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+		result.Status = 0
+		result.Error = ""
+		if resp != nil {
+			result.Status = resp.Status
+		}
+		if err != nil {
+			result.Error = err.Error()
+		}
+
+		retry := err != nil || result.Status >= 500 || result.Status == http.StatusTooManyRequests
+		if !retry || result.Attempts > retries {
+			return result
+		}
+
+		debugLog("Retrying forward to", t.URL, "in", backoff)
+		time.Sleep(backoff)
+		backoff *= 2
+	}
+}
+
+// UpstreamResponse is the response to a request sent on to another server.
+type UpstreamResponse struct {
+	Status  int               `json:"status"`
+	Headers map[string]string `json:"headers"`
+	Body    string            `json:"body"`
+}
+
+// sendRequest re-issues gr, with its original method, headers and body, to the given URL. Any
+// headers in `overrides` replace the original ones. The response body is limited to the first 1MB.
+func sendRequest(gr *GeobinRequest, target string, overrides map[string]string) (*UpstreamResponse, error) {
+	method := gr.Method
+	if method == "" {
+		method = "POST"
+	}
+
+	req, err := http.NewRequest(method, target, strings.NewReader(gr.Body))
+	if err != nil {
+		return nil, err
+	}
+
+	for k, v := range gr.Headers {
+		if !hopByHopHeaders[http.CanonicalHeaderKey(k)] {
+			req.Header.Set(k, v)
+		}
+	}
+	for k, v := range overrides {
+		req.Header.Set(k, v)
+	}
+
+	resp, err := forwardClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	upstream := &UpstreamResponse{
+		Status:  resp.StatusCode,
+		Headers: make(map[string]string),
+	}
+	for k, v := range resp.Header {
+		upstream.Headers[k] = strings.Join(v, ", ")
+	}
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return upstream, errors.New(fmt.Sprint("Error reading response: ", err))
+	}
+	upstream.Body = string(body)
+
+	// read the rest of the body so the connection can be reused
+	io.Copy(ioutil.Discard, resp.Body)
+
+	return upstream, nil
+}
@@ -0,0 +1,219 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSendRequest(t *testing.T) {
+	defer allowPrivateTargets()()
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "PUT", r.Method)
+		assert.Equal(t, "application/json", r.Header.Get("Content-Type"))
+		assert.Equal(t, "override", r.Header.Get("X-Token"))
+		assert.Equal(t, "", r.Header.Get("Connection"))
+		body, _ := ioutil.ReadAll(r.Body)
+		assert.Equal(t, `{"lat": 10, "lng": -10}`, string(body))
+
+		w.Header().Set("X-Upstream", "yes")
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("created"))
+	}))
+	defer ts.Close()
+
+	gr := NewGeobinRequest(0, map[string]string{
+		"Content-Type": "application/json",
+		"X-Token":      "original",
+		"Connection":   "close",
+	}, []byte(`{"lat": 10, "lng": -10}`))
+	gr.Method = "PUT"
+
+	resp, err := sendRequest(gr, ts.URL, map[string]string{"X-Token": "override"})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, http.StatusCreated, resp.Status)
+	assert.Equal(t, "yes", resp.Headers["X-Upstream"])
+	assert.Equal(t, "created", resp.Body)
+}
+
+func TestForwardWithRetries(t *testing.T) {
+	defer allowPrivateTargets()()
+	backoff := forwardBackoff
+	forwardBackoff = time.Millisecond
+	defer func() {
+		forwardBackoff = backoff
+	}()
+
+	var calls int32
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "1", r.Header.Get(forwardedHeader))
+		if atomic.AddInt32(&calls, 1) < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer ts.Close()
+
+	gr := NewGeobinRequest(0, nil, []byte(`{}`))
+
+	// succeeds on the third attempt
+	result := forwardWithRetries(gr, ForwardTarget{URL: ts.URL}, 3)
+	assert.Equal(t, 3, result.Attempts)
+	assert.Equal(t, http.StatusOK, result.Status)
+	assert.Equal(t, "", result.Error)
+
+	// gives up after the retries run out
+	atomic.StoreInt32(&calls, 0)
+	result = forwardWithRetries(gr, ForwardTarget{URL: ts.URL}, 1)
+	assert.Equal(t, 2, result.Attempts)
+	assert.Equal(t, http.StatusServiceUnavailable, result.Status)
+
+	// connection failures are reported as errors
+	result = forwardWithRetries(gr, ForwardTarget{URL: "http://127.0.0.1:1"}, 0)
+	assert.Equal(t, 1, result.Attempts)
+	assert.Equal(t, 0, result.Status)
+	assert.NotEqual(t, "", result.Error)
+}
+
+func TestForwardSettingsValidate(t *testing.T) {
+	retries := func(n int) *int {
+		return &n
+	}
+
+	assert.Equal(t, nil, ForwardSettings{Targets: []ForwardTarget{{URL: "https://example.com/hook"}}}.validate())
+	assert.Equal(t, nil, ForwardSettings{Retries: retries(0)}.validate())
+
+	for _, fs := range []ForwardSettings{
+		{Targets: []ForwardTarget{{URL: "example.com/hook"}}},
+		{Targets: []ForwardTarget{{URL: "ftp://example.com/hook"}}},
+		{Retries: retries(-1)},
+		{Retries: retries(maxForwardRetries + 1)},
+	} {
+		assert.NotEqual(t, nil, fs.validate())
+	}
+
+	assert.Equal(t, defaultForwardRetries, ForwardSettings{}.retries())
+	assert.Equal(t, 5, ForwardSettings{Retries: retries(5)}.retries())
+}
+
+func TestValidateTargetURLPrivate(t *testing.T) {
+	for _, u := range []string{
+		"http://127.0.0.1/hook",
+		"http://localhost:8080/hook",
+		"http://10.1.2.3/hook",
+		"http://192.168.0.1/hook",
+		"http://169.254.169.254/latest/meta-data/",
+		"http://0.0.0.0/hook",
+		"http://[::1]/hook",
+		"http://[fe80::1]/hook",
+	} {
+		assert.NotEqual(t, nil, validateTargetURL(u), u)
+	}
+	assert.Equal(t, nil, validateTargetURL("http://203.0.113.7/hook"))
+
+	defer allowPrivateTargets()()
+	assert.Equal(t, nil, validateTargetURL("http://127.0.0.1/hook"))
+}
+
+func TestValidateTargetURLSelf(t *testing.T) {
+	host := config.Host
+	defer func() { config.Host = host }()
+	config.Host = "geobin.example.com"
+	assert.NotEqual(t, nil, validateTargetURL("https://geobin.example.com/PF4C5zm67N"))
+	assert.NotEqual(t, nil, validateTargetURL("https://GEOBIN.example.com:8080/PF4C5zm67N"))
+}
+
+func TestSendRequestPrivate(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		t.Error("Request reached a loopback address")
+	}))
+	defer ts.Close()
+
+	// checked when connecting, whatever the URL was when it was validated
+	_, err := sendRequest(NewGeobinRequest(0, nil, []byte(`{}`)), ts.URL, nil)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestForwardRequest(t *testing.T) {
+	defer allowPrivateTargets()()
+	received := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		received <- string(body)
+		w.WriteHeader(http.StatusAccepted)
+	}))
+	defer ts.Close()
+
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+	err = setBinSetting(binId, forwardsSetting, ForwardSettings{Targets: []ForwardTarget{{URL: ts.URL}}})
+	assert.Equal(t, nil, err)
+	// redaction only applies to the stored copy
+	err = setBinSetting(binId, redactionSetting, RedactionRules{Paths: []string{"$.lat"}})
+	assert.Equal(t, nil, err)
+
+	if _, err := postToBin(binId, `{"lat": 10, "lng": -10}`); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case body := <-received:
+		assert.Equal(t, `{"lat": 10, "lng": -10}`, body)
+	case <-time.After(time.Second):
+		t.Fatal("Request was never forwarded")
+	}
+
+	// the result is recorded on the stored request once the forward is done
+	var forwards []ForwardResult
+	for i := 0; i < 50 && forwards == nil; i++ {
+		time.Sleep(10 * time.Millisecond)
+		history, err := getHistory(binId)
+		if err != nil {
+			t.Error(err)
+		}
+		forwards = history[0].Forwards
+	}
+
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, `{"lat":"[REDACTED]","lng":-10}`, history[0].Body)
+
+	assert.Equal(t, 1, len(forwards))
+	assert.Equal(t, ts.URL, forwards[0].URL)
+	assert.Equal(t, http.StatusAccepted, forwards[0].Status)
+	assert.Equal(t, 1, forwards[0].Attempts)
+
+	// requests that were forwarded by a bin aren't forwarded again
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/"+binId, strings.NewReader(`{"lat": 10, "lng": -10}`))
+	if err != nil {
+		t.Error(err)
+	}
+	req.Header.Set(forwardedHeader, "1")
+	binHandler(httptest.NewRecorder(), req)
+	select {
+	case <-received:
+		t.Error("Forwarded request was forwarded again")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+// allowPrivateTargets lets outbound requests reach the test servers on loopback, until the
+// returned function is called.
+func allowPrivateTargets() func() {
+	allow := config.AllowPrivateTargets
+	config.AllowPrivateTargets = true
+	return func() {
+		config.AllowPrivateTargets = allow
+	}
+}
@@ -18,6 +18,7 @@ var config = &Config{}
 var client = &redis.Client{}
 var pubsub = &redis.PubSub{}
 var socketMap SocketMap
+var presence *presenceMap
 var isDebug = flag.Bool("debug", false, "Boolean flag indicates a debug build. Affects log statements.")
 var isVerbose = flag.Bool("verbose", false, "Boolean flag indicates you want to see a lot of log messages.")
 
@@ -42,6 +43,8 @@ func main() {
 
 	// loop for receiving messages from Redis pubsub, and forwarding them on to relevant ws connection
 	go redisPump()
+	// loop for keeping this server's viewers from being counted as gone
+	go presence.presencePump()
 
 	defer func() {
 		pubsub.Close()
@@ -69,7 +72,8 @@ func setupRedis() {
 	}
 	pubsub = client.PubSub()
 
-	socketMap = NewSocketMap(pubsub)
+	presence = newPresenceMap(NewSocketMap(pubsub))
+	socketMap = presence
 }
 
 // redisPump reads messages out of redis and pushes them through the
@@ -87,6 +91,9 @@ func redisPump() {
 			if err = socketMap.Send(v.Channel, []byte(v.Payload)); err != nil {
 				log.Println(err)
 			}
+			if payloadType([]byte(v.Payload)) == "deleted" {
+				dropBin(v.Channel)
+			}
 		}
 	}
 }
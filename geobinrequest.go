@@ -2,29 +2,63 @@ package main
 
 import (
 	"encoding/json"
+	"fmt"
 	"log"
 	"reflect"
 	"runtime"
+	"strconv"
 	"strings"
 	"sync"
+	"time"
 
 	gj "github.com/kpawlik/geojson"
 )
 
 // GeobinRequest stores received data and any detected geo info from a request
 type GeobinRequest struct {
+	ID        string            `json:"id,omitempty"`
 	Timestamp int64             `json:"timestamp"`
+	Method    string            `json:"method,omitempty"`
 	Headers   map[string]string `json:"headers"`
 	Body      string            `json:"body"`
 	Geo       []Geo             `json:"geo,omitempty"`
+	Event     *FenceEvent       `json:"event,omitempty"`     // set on the entries recording fence events
+	Forwards  []ForwardResult   `json:"forwards,omitempty"`  // results of passing the request on to the bin's forward targets
+	Signature *SignatureResult  `json:"signature,omitempty"` // set when the bin checks request signatures
+	Redacted  []string          `json:"redacted,omitempty"`  // where values were removed by the redaction rules, like "headers.Authorization"
 	wg        sync.WaitGroup
 	lk        sync.Mutex
 }
 
 type Geo struct {
-	Geo    map[string]interface{} `json:"geo"`
-	Radius float64                `json:"radius,omitempty"`
-	Path   []interface{}          `json:"path"`
+	Geo      map[string]interface{} `json:"geo"`
+	Radius   float64                `json:"radius,omitempty"` // in meters
+	Circle   map[string]interface{} `json:"circle,omitempty"`
+	Altitude *float64               `json:"altitude,omitempty"` // in meters
+	Heading  *float64               `json:"heading,omitempty"`
+	Speed    *float64               `json:"speed,omitempty"`
+	Time     int64                  `json:"time,omitempty"` // device timestamp in Unix time (milis)
+	Path     []interface{}          `json:"path"`
+}
+
+// meters per unit for each of the length units we recognize
+var lengthUnits = map[string]float64{
+	"m":          1,
+	"meter":      1,
+	"meters":     1,
+	"metre":      1,
+	"metres":     1,
+	"km":         1000,
+	"kilometer":  1000,
+	"kilometers": 1000,
+	"kilometre":  1000,
+	"kilometres": 1000,
+	"mi":         1609.344,
+	"mile":       1609.344,
+	"miles":      1609.344,
+	"ft":         0.3048,
+	"foot":       0.3048,
+	"feet":       0.3048,
 }
 
 // NewGeobinRequest creates a new GeobinRequest with the given timestamp,
@@ -44,6 +78,43 @@ func NewGeobinRequest(timestamp int64, headers map[string]string, body []byte) *
 	return &gr
 }
 
+// newRequestID returns a unique ID for a GeobinRequest with the given timestamp. IDs are the
+// timestamp, so that a request can be found by its score in the bin's sorted set, followed by the
+// current time in nanoseconds, so that IDs created by the same server sort in creation order.
+func newRequestID(timestamp int64) string {
+	return fmt.Sprintf("%d-%d", timestamp, time.Now().UnixNano())
+}
+
+// parseRequestID returns the timestamp and creation time of the given request ID, along with a
+// boolean reflecting whether or not it is a valid ID.
+func parseRequestID(id string) (int64, int64, bool) {
+	parts := strings.Split(id, "-")
+	if len(parts) != 2 {
+		return 0, 0, false
+	}
+
+	ts, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+	created, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil {
+		return 0, 0, false
+	}
+
+	return ts, created, true
+}
+
+// payloadID returns the ID of the GeobinRequest encoded in the given JSON payload, or an empty
+// string if it doesn't have one.
+func payloadID(payload []byte) string {
+	var gr struct {
+		ID string `json:"id"`
+	}
+	json.Unmarshal(payload, &gr)
+	return gr.ID
+}
+
 // Parse parses `gr.Body` and fills `gr.Geo` with any geographic data it finds.
 func (gr *GeobinRequest) Parse() {
 	var js interface{}
@@ -80,6 +151,12 @@ func (gr *GeobinRequest) parse(b interface{}, kp []interface{}) {
 }
 
 func (gr *GeobinRequest) appendGeo(geo Geo) {
+	if geo.Radius > 0 && config.CircleSegments > 0 {
+		if lng, lat, ok := pointCoords(geo.Geo); ok {
+			geo.Circle = circlePolygon(lng, lat, geo.Radius, config.CircleSegments)
+		}
+	}
+
 	gr.lk.Lock()
 	defer gr.lk.Unlock()
 	gr.Geo = append(gr.Geo, geo)
@@ -94,6 +171,11 @@ func (gr *GeobinRequest) parseObject(o map[string]interface{}, kp []interface{})
 			Path: kp,
 			Geo:  o,
 		}
+		if props, ok := o["properties"].(map[string]interface{}); ok {
+			if _, _, isPoint := pointCoords(o); isPoint {
+				findMeasurements(props).apply(&g)
+			}
+		}
 		gr.appendGeo(g)
 	} else if foundGeo, geo := isOtherGeo(o); foundGeo {
 		geo.Path = kp
@@ -132,13 +214,19 @@ func (gr *GeobinRequest) parseArray(a []interface{}, kp []interface{}) {
 //	"rad", "radius"
 //	"acc", "accuracy"
 //
+// The radius is normalized to meters. Its units are taken from a suffix on the radius key
+// (e.g. "accuracy_km", "radius_mi") or from a sibling "unit" or "units" string, defaulting to
+// meters. See `lengthUnits` for the recognized unit names.
+//
+// Altitude, heading, speed and the device timestamp are also picked up, see `findMeasurements`.
+//
 // The following keys will be searched for a long/lat pair:
 //	"geo"
 //	"loc" or "location"
 //	"coord", "coords", "coordinate" or "coordinates"
 func isOtherGeo(o map[string]interface{}) (bool, *Geo) {
-	var foundLat, foundLng, foundDst bool
-	var lat, lng, dst float64
+	var foundLat, foundLng bool
+	var lat, lng float64
 
 	for k, v := range o {
 		switch strings.ToLower(k) {
@@ -146,8 +234,6 @@ func isOtherGeo(o map[string]interface{}) (bool, *Geo) {
 			lat, foundLat = v.(float64)
 		case "lng", "lon", "long", "longitude", "x":
 			lng, foundLng = v.(float64)
-		case "dst", "dist", "distance", "rad", "radius", "acc", "accuracy":
-			dst, foundDst = v.(float64)
 		case "geo", "loc", "location", "coord", "coordinate", "coords", "coordinates":
 			g, ok := v.([]float64)
 			if !ok || len(g) != 2 {
@@ -168,15 +254,168 @@ func isOtherGeo(o map[string]interface{}) (bool, *Geo) {
 		g := &Geo{
 			Geo: geo,
 		}
-		if foundDst {
-			g.Radius = dst
-		}
+		findMeasurements(o).apply(g)
 		return true, g
 	}
 
 	return false, nil
 }
 
+// measurements holds the values found alongside a set of coordinates
+type measurements struct {
+	radius   *float64
+	altitude *float64
+	heading  *float64
+	speed    *float64
+	time     int64
+}
+
+// apply copies any values found into the given Geo
+func (m measurements) apply(g *Geo) {
+	if m.radius != nil {
+		g.Radius = *m.radius
+	}
+	g.Altitude = m.altitude
+	g.Heading = m.heading
+	g.Speed = m.speed
+	g.Time = m.time
+}
+
+// findMeasurements searches the given json map for values that describe the coordinates
+// found in it.
+//
+// The following keys will be used as the radius, converted to meters:
+//	"dst", "dist", "distance"
+//	"rad", "radius"
+//	"acc", "accuracy"
+//
+// The following keys will be used as the altitude, converted to meters:
+//	"alt", "altitude"
+//	"ele", "elevation"
+//
+// The following keys will be used as the heading, in degrees:
+//	"heading", "bearing", "course"
+//
+// The following keys will be used as the speed:
+//	"speed", "velocity"
+//
+// The following keys will be used as the device timestamp, see `parseDeviceTime`:
+//	"time", "timestamp", "ts"
+//
+// Units for radius and altitude come from a suffix on the key (e.g. "alt_ft") or a sibling
+// "unit" or "units" string, and default to meters.
+func findMeasurements(o map[string]interface{}) measurements {
+	var m measurements
+	var siblingUnit, radiusUnit, altitudeUnit string
+
+	for k, v := range o {
+		key := strings.ToLower(k)
+		if key == "unit" || key == "units" {
+			siblingUnit, _ = v.(string)
+			continue
+		}
+
+		base, suffix := key, ""
+		if i := strings.LastIndexAny(key, "_-"); i > 0 {
+			if _, ok := lengthUnits[key[i+1:]]; ok {
+				base, suffix = key[:i], key[i+1:]
+			}
+		}
+
+		f, isNum := v.(float64)
+		switch base {
+		case "dst", "dist", "distance", "rad", "radius", "acc", "accuracy":
+			if isNum {
+				m.radius, radiusUnit = &f, suffix
+			}
+		case "alt", "altitude", "ele", "elevation":
+			if isNum {
+				m.altitude, altitudeUnit = &f, suffix
+			}
+		case "heading", "bearing", "course":
+			if isNum {
+				m.heading = &f
+			}
+		case "speed", "velocity":
+			if isNum {
+				m.speed = &f
+			}
+		case "time", "timestamp", "ts":
+			if t, ok := parseDeviceTime(v); ok {
+				m.time = t
+			}
+		}
+	}
+
+	siblingUnit = strings.ToLower(strings.TrimSpace(siblingUnit))
+	if m.radius != nil {
+		m.radius = toMeters(*m.radius, radiusUnit, siblingUnit)
+	}
+	if m.altitude != nil {
+		m.altitude = toMeters(*m.altitude, altitudeUnit, siblingUnit)
+	}
+
+	return m
+}
+
+// toMeters converts the given length to meters using the unit suffix from its key if there is
+// one, or the unit named by a sibling field otherwise. Unknown units are assumed to be meters.
+func toMeters(v float64, suffix, siblingUnit string) *float64 {
+	unit := suffix
+	if unit == "" {
+		unit = siblingUnit
+	}
+	if unit == "" {
+		return &v
+	}
+
+	factor, ok := lengthUnits[unit]
+	if !ok {
+		debugLog("Unknown length unit, assuming meters:", unit)
+		return &v
+	}
+
+	m := v * factor
+	return &m
+}
+
+// Epoch timestamps larger than this are assumed to be in milliseconds rather than seconds.
+// As seconds it is sometime in the year 5138.
+const epochMillisThreshold = 1e11
+
+// parseDeviceTime reads a device timestamp, either an ISO 8601 string or a number (or numeric
+// string) of seconds or milliseconds since the Unix epoch, and returns it in Unix time (milis),
+// along with a boolean reflecting whether or not it could be parsed.
+func parseDeviceTime(v interface{}) (int64, bool) {
+	var epoch float64
+	switch t := v.(type) {
+	case float64:
+		epoch = t
+	case string:
+		if f, err := strconv.ParseFloat(t, 64); err == nil {
+			epoch = f
+			break
+		}
+
+		for _, layout := range []string{time.RFC3339Nano, "2006-01-02T15:04:05.999999999Z0700", "2006-01-02T15:04:05.999999999", "2006-01-02 15:04:05.999999999"} {
+			if ts, err := time.Parse(layout, t); err == nil {
+				return ts.UnixNano() / int64(time.Millisecond), true
+			}
+		}
+		return 0, false
+	default:
+		return 0, false
+	}
+
+	if epoch <= 0 {
+		return 0, false
+	}
+	if epoch < epochMillisThreshold {
+		epoch *= 1000
+	}
+	return int64(epoch), true
+}
+
 // isGeojson detects whether or not the given json map is valid GeoJSON and
 // returns a boolean reflecting its findings.
 func isGeojson(js map[string]interface{}) bool {
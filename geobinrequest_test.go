@@ -340,6 +340,64 @@ func TestIsOtherGeoDistKeys(t *testing.T) {
 	debugLog("===End TestIsOtherGeoDistKeys===")
 }
 
+// Ensure that radius units are detected from key suffixes and sibling unit fields
+// and normalized to meters
+func TestIsOtherGeoDistUnits(t *testing.T) {
+	runTest := func(o map[string]interface{}, radius float64) {
+		o["x"] = float64(10)
+		o["y"] = float64(-10)
+		runIsOtherGeoTest(t, o, true, &Geo{
+			Geo: map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []interface{}{float64(10), float64(-10)},
+			},
+			Radius: radius,
+		})
+	}
+
+	debugLog("===Begin TestIsOtherGeoDistUnits===")
+	runTest(map[string]interface{}{"accuracy_m": float64(5)}, 5)
+	runTest(map[string]interface{}{"radius_km": float64(2)}, 2000)
+	runTest(map[string]interface{}{"dist-ft": float64(10)}, 3.048)
+	runTest(map[string]interface{}{"distance": float64(1), "units": "mi"}, 1609.344)
+	runTest(map[string]interface{}{"acc": float64(3), "unit": "Kilometers"}, 3000)
+	runTest(map[string]interface{}{"rad_km": float64(1), "units": "mi"}, 1000)
+	runTest(map[string]interface{}{"radius": float64(7), "units": "furlongs"}, 7)
+	debugLog("===End TestIsOtherGeoDistUnits===")
+}
+
+// Ensure that altitude, heading, speed and device time are picked up alongside the coordinates
+func TestIsOtherGeoMeasurements(t *testing.T) {
+	runTest := func(o map[string]interface{}, exp Geo) {
+		o["x"] = float64(10)
+		o["y"] = float64(-10)
+		exp.Geo = map[string]interface{}{
+			"type":        "Point",
+			"coordinates": []interface{}{float64(10), float64(-10)},
+		}
+		runIsOtherGeoTest(t, o, true, &exp)
+	}
+	f := func(v float64) *float64 {
+		return &v
+	}
+
+	debugLog("===Begin TestIsOtherGeoMeasurements===")
+	runTest(map[string]interface{}{
+		"alt":     float64(0),
+		"heading": float64(90),
+		"speed":   float64(12.5),
+	}, Geo{Altitude: f(0), Heading: f(90), Speed: f(12.5)})
+	runTest(map[string]interface{}{"altitude_ft": float64(100)}, Geo{Altitude: f(30.48)})
+	runTest(map[string]interface{}{"elevation": float64(2), "acc": float64(3), "units": "km"}, Geo{Altitude: f(2000), Radius: 3000})
+	runTest(map[string]interface{}{"bearing": float64(270), "velocity": float64(3)}, Geo{Heading: f(270), Speed: f(3)})
+	runTest(map[string]interface{}{"timestamp": "2014-04-26T22:39:26.513+00:00"}, Geo{Time: 1398551966513})
+	runTest(map[string]interface{}{"time": float64(1398551966)}, Geo{Time: 1398551966000})
+	runTest(map[string]interface{}{"ts": float64(1398551966513)}, Geo{Time: 1398551966513})
+	runTest(map[string]interface{}{"ts": "1398551966"}, Geo{Time: 1398551966000})
+	runTest(map[string]interface{}{"time": "yesterday"}, Geo{})
+	debugLog("===End TestIsOtherGeoMeasurements===")
+}
+
 // Ensure that we find geo objects for all variations of the key name.
 func TestIsOtherGeoGeoKeys(t *testing.T) {
 	geoKeys := []string{"geo", "loc", "location", "coord", "coordinate", "coords", "coordinates"}
@@ -371,6 +429,7 @@ func TestGTCallbackRequest(t *testing.T) {
 				"coordinates": []interface{}{-122.67545711249113, 45.51986460661744},
 			},
 			Radius: 8,
+			Time:   1398551966513,
 			Path:   []interface{}{"location"},
 		},
 		Geo{
@@ -392,3 +451,38 @@ func TestGTCallbackRequest(t *testing.T) {
 
 	testSlicesContainSameGeos(t, expected, gr.Geo)
 }
+
+func TestGeoJSONPointFeatureRadius(t *testing.T) {
+	src := []byte(`{
+		"type": "Feature",
+		"geometry": { "type": "Point", "coordinates": [-122.5, 45.5] },
+		"properties": { "accuracy_km": 1.5 }
+	}`)
+
+	gr := NewGeobinRequest(0, nil, src)
+
+	assert.Equal(t, 1, len(gr.Geo))
+	assert.Equal(t, float64(1500), gr.Geo[0].Radius)
+}
+
+func TestCircleGeneration(t *testing.T) {
+	segments := config.CircleSegments
+	config.CircleSegments = 16
+	defer func() {
+		config.CircleSegments = segments
+	}()
+
+	gr := NewGeobinRequest(0, nil, []byte(`{"lat": 45.5, "lng": -122.5, "radius": 100}`))
+
+	assert.Equal(t, 1, len(gr.Geo))
+	circle := gr.Geo[0].Circle
+	assert.Equal(t, "Polygon", circle["type"])
+	ring := circle["coordinates"].([]interface{})[0].([]interface{})
+	assert.Equal(t, 17, len(ring))
+	assert.Equal(t, ring[0], ring[16])
+
+	// no radius, no circle
+	gr = NewGeobinRequest(0, nil, []byte(`{"lat": 45.5, "lng": -122.5}`))
+	assert.Equal(t, 1, len(gr.Geo))
+	assert.Equal(t, map[string]interface{}(nil), gr.Geo[0].Circle)
+}
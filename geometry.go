@@ -0,0 +1,361 @@
+package main
+
+import (
+	"math"
+)
+
+// mean radius of the earth in meters
+const earthRadius = 6371008.8
+
+// toRadians converts degrees to radians
+func toRadians(deg float64) float64 {
+	return deg * math.Pi / 180
+}
+
+// toDegrees converts radians to degrees
+func toDegrees(rad float64) float64 {
+	return rad * 180 / math.Pi
+}
+
+// destination returns the lng/lat reached by travelling `dist` meters from lng/lat
+// along the given bearing (in degrees clockwise from north) on a spherical earth.
+func destination(lng, lat, dist, bearing float64) (float64, float64) {
+	d := dist / earthRadius
+	b := toRadians(bearing)
+	lat1 := toRadians(lat)
+	lng1 := toRadians(lng)
+
+	lat2 := math.Asin(math.Sin(lat1)*math.Cos(d) + math.Cos(lat1)*math.Sin(d)*math.Cos(b))
+	lng2 := lng1 + math.Atan2(math.Sin(b)*math.Sin(d)*math.Cos(lat1), math.Cos(d)-math.Sin(lat1)*math.Sin(lat2))
+
+	// normalise the longitude to [-180, 180]
+	return math.Mod(toDegrees(lng2)+540, 360) - 180, toDegrees(lat2)
+}
+
+// fewest segments that make a polygon
+const minCircleSegments = 3
+
+// circlePolygon returns a GeoJSON Polygon approximating a circle of `radius` meters around
+// lng/lat using the given number of segments, at least minCircleSegments. The ring is closed, so it
+// holds segments+1 positions.
+func circlePolygon(lng, lat, radius float64, segments int) map[string]interface{} {
+	if segments < minCircleSegments {
+		segments = minCircleSegments
+	}
+	ring := make([]interface{}, 0, segments+1)
+	for i := 0; i < segments; i++ {
+		x, y := destination(lng, lat, radius, float64(i)*360/float64(segments))
+		ring = append(ring, []interface{}{x, y})
+	}
+	ring = append(ring, ring[0])
+
+	return map[string]interface{}{
+		"type":        "Polygon",
+		"coordinates": []interface{}{ring},
+	}
+}
+
+// pointCoords returns the lng/lat of a GeoJSON Point, or of a Feature whose geometry is a
+// Point, along with a boolean reflecting whether or not a point was found.
+func pointCoords(geo map[string]interface{}) (float64, float64, bool) {
+	switch geo["type"] {
+	case "Point":
+		c, ok := geo["coordinates"].([]interface{})
+		if !ok || len(c) < 2 {
+			return 0, 0, false
+		}
+		lng, lngOk := c[0].(float64)
+		lat, latOk := c[1].(float64)
+		return lng, lat, lngOk && latOk
+	case "Feature":
+		g, ok := geo["geometry"].(map[string]interface{})
+		if !ok {
+			return 0, 0, false
+		}
+		return pointCoords(g)
+	}
+	return 0, 0, false
+}
+
+// distance returns the great-circle distance in meters between two lng/lat positions
+// using the haversine formula.
+func distance(lng1, lat1, lng2, lat2 float64) float64 {
+	dLat := toRadians(lat2 - lat1)
+	dLng := toRadians(lng2 - lng1)
+
+	a := math.Sin(dLat/2)*math.Sin(dLat/2) +
+		math.Cos(toRadians(lat1))*math.Cos(toRadians(lat2))*math.Sin(dLng/2)*math.Sin(dLng/2)
+	return 2 * earthRadius * math.Atan2(math.Sqrt(a), math.Sqrt(1-a))
+}
+
+// rect is an axis aligned bounding box in lng/lat
+type rect struct {
+	minX, minY, maxX, maxY float64
+}
+
+// intersects returns true if r and o overlap or touch
+func (r rect) intersects(o rect) bool {
+	return r.minX <= o.maxX && o.minX <= r.maxX && r.minY <= o.maxY && o.minY <= r.maxY
+}
+
+// extend returns the smallest rect containing both r and o
+func (r rect) extend(o rect) rect {
+	return rect{
+		minX: math.Min(r.minX, o.minX),
+		minY: math.Min(r.minY, o.minY),
+		maxX: math.Max(r.maxX, o.maxX),
+		maxY: math.Max(r.maxY, o.maxY),
+	}
+}
+
+// shape is a GeoJSON object broken down into the points, lines and polygons it contains.
+// Positions are [lng, lat] and polygons are lists of rings, the first being the outer ring.
+type shape struct {
+	points   [][2]float64
+	lines    [][][2]float64
+	polygons [][][][2]float64
+}
+
+// toShape breaks down any GeoJSON geometry, Feature or FeatureCollection into a shape.
+// Anything it doesn't understand is ignored.
+func toShape(geo map[string]interface{}) shape {
+	var s shape
+	s.add(geo)
+	return s
+}
+
+func (s *shape) add(geo map[string]interface{}) {
+	c := geo["coordinates"]
+	switch geo["type"] {
+	case "Point":
+		if p, ok := toPosition(c); ok {
+			s.points = append(s.points, p)
+		}
+	case "MultiPoint":
+		s.points = append(s.points, toPositions(c)...)
+	case "LineString":
+		s.lines = append(s.lines, toPositions(c))
+	case "MultiLineString":
+		for _, l := range toSlice(c) {
+			s.lines = append(s.lines, toPositions(l))
+		}
+	case "Polygon":
+		s.polygons = append(s.polygons, toRings(c))
+	case "MultiPolygon":
+		for _, p := range toSlice(c) {
+			s.polygons = append(s.polygons, toRings(p))
+		}
+	case "GeometryCollection":
+		for _, g := range toSlice(geo["geometries"]) {
+			if m, ok := g.(map[string]interface{}); ok {
+				s.add(m)
+			}
+		}
+	case "Feature":
+		if m, ok := geo["geometry"].(map[string]interface{}); ok {
+			s.add(m)
+		}
+	case "FeatureCollection":
+		for _, f := range toSlice(geo["features"]) {
+			if m, ok := f.(map[string]interface{}); ok {
+				s.add(m)
+			}
+		}
+	}
+}
+
+func toSlice(v interface{}) []interface{} {
+	s, _ := v.([]interface{})
+	return s
+}
+
+func toPosition(v interface{}) ([2]float64, bool) {
+	c := toSlice(v)
+	if len(c) < 2 {
+		return [2]float64{}, false
+	}
+	lng, lngOk := c[0].(float64)
+	lat, latOk := c[1].(float64)
+	return [2]float64{lng, lat}, lngOk && latOk
+}
+
+func toPositions(v interface{}) [][2]float64 {
+	positions := make([][2]float64, 0)
+	for _, c := range toSlice(v) {
+		if p, ok := toPosition(c); ok {
+			positions = append(positions, p)
+		}
+	}
+	return positions
+}
+
+func toRings(v interface{}) [][][2]float64 {
+	rings := make([][][2]float64, 0)
+	for _, r := range toSlice(v) {
+		rings = append(rings, toPositions(r))
+	}
+	return rings
+}
+
+// bounds returns the bounding box of every position in the shape, along with a boolean that
+// is false if the shape has no positions.
+func (s shape) bounds() (rect, bool) {
+	var r rect
+	found := false
+	add := func(p [2]float64) {
+		pr := rect{p[0], p[1], p[0], p[1]}
+		if !found {
+			r, found = pr, true
+			return
+		}
+		r = r.extend(pr)
+	}
+
+	for _, p := range s.points {
+		add(p)
+	}
+	for _, l := range s.lines {
+		for _, p := range l {
+			add(p)
+		}
+	}
+	for _, poly := range s.polygons {
+		if len(poly) > 0 {
+			for _, p := range poly[0] {
+				add(p)
+			}
+		}
+	}
+
+	return r, found
+}
+
+// intersects returns true if any part of s touches any of the polygons in area. Positions are
+// treated as planar lng/lat, which is good enough at the scales we deal with.
+func (s shape) intersects(area shape) bool {
+	for _, p := range s.points {
+		if area.contains(p) {
+			return true
+		}
+	}
+
+	for _, l := range s.lines {
+		for _, p := range l {
+			if area.contains(p) {
+				return true
+			}
+		}
+		if area.crosses(l) {
+			return true
+		}
+	}
+
+	for _, poly := range s.polygons {
+		if len(poly) == 0 {
+			continue
+		}
+		for _, p := range poly[0] {
+			if area.contains(p) {
+				return true
+			}
+		}
+		if area.crosses(poly[0]) {
+			return true
+		}
+
+		// the area may be entirely inside of this polygon
+		inner := shape{polygons: [][][][2]float64{poly}}
+		for _, a := range area.polygons {
+			if len(a) > 0 && len(a[0]) > 0 && inner.contains(a[0][0]) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// contains returns true if p is inside any of the shape's polygons (and outside of their holes)
+func (s shape) contains(p [2]float64) bool {
+	for _, poly := range s.polygons {
+		if len(poly) == 0 || !ringContains(poly[0], p) {
+			continue
+		}
+
+		inHole := false
+		for _, hole := range poly[1:] {
+			if ringContains(hole, p) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+	return false
+}
+
+// crosses returns true if any segment of the line crosses an edge of any of the shape's polygons
+func (s shape) crosses(line [][2]float64) bool {
+	for _, poly := range s.polygons {
+		for _, ring := range poly {
+			for i := 1; i < len(line); i++ {
+				for j := 1; j < len(ring); j++ {
+					if segmentsIntersect(line[i-1], line[i], ring[j-1], ring[j]) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+// ringContains uses ray casting to determine whether or not p is inside the ring
+func ringContains(ring [][2]float64, p [2]float64) bool {
+	in := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		a, b := ring[i], ring[j]
+		if (a[1] > p[1]) != (b[1] > p[1]) && p[0] < (b[0]-a[0])*(p[1]-a[1])/(b[1]-a[1])+a[0] {
+			in = !in
+		}
+	}
+	return in
+}
+
+// segmentsIntersect returns true if segment a1-a2 touches segment b1-b2
+func segmentsIntersect(a1, a2, b1, b2 [2]float64) bool {
+	d1 := orientation(b1, b2, a1)
+	d2 := orientation(b1, b2, a2)
+	d3 := orientation(a1, a2, b1)
+	d4 := orientation(a1, a2, b2)
+
+	if ((d1 > 0 && d2 < 0) || (d1 < 0 && d2 > 0)) && ((d3 > 0 && d4 < 0) || (d3 < 0 && d4 > 0)) {
+		return true
+	}
+
+	return (d1 == 0 && onSegment(b1, b2, a1)) ||
+		(d2 == 0 && onSegment(b1, b2, a2)) ||
+		(d3 == 0 && onSegment(a1, a2, b1)) ||
+		(d4 == 0 && onSegment(a1, a2, b2))
+}
+
+// orientation returns the cross product of (b-a) and (c-a), which is positive when a, b, c turn
+// counter-clockwise, negative when they turn clockwise and 0 when they're collinear.
+func orientation(a, b, c [2]float64) float64 {
+	return (b[0]-a[0])*(c[1]-a[1]) - (b[1]-a[1])*(c[0]-a[0])
+}
+
+// onSegment returns true if p, which is collinear with a-b, lies between a and b
+func onSegment(a, b, p [2]float64) bool {
+	return math.Min(a[0], b[0]) <= p[0] && p[0] <= math.Max(a[0], b[0]) &&
+		math.Min(a[1], b[1]) <= p[1] && p[1] <= math.Max(a[1], b[1])
+}
+
+// rectShape returns a shape holding a single polygon covering r
+func rectShape(r rect) shape {
+	return shape{polygons: [][][][2]float64{{{
+		{r.minX, r.minY}, {r.maxX, r.minY}, {r.maxX, r.maxY}, {r.minX, r.maxY}, {r.minX, r.minY},
+	}}}}
+}
@@ -0,0 +1,167 @@
+package main
+
+import (
+	"math"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func assertCloseTo(t *testing.T, exp, got, tolerance float64) {
+	if math.Abs(exp-got) > tolerance {
+		t.Errorf("Expected %v to be within %v of %v", got, tolerance, exp)
+	}
+}
+
+func TestDestination(t *testing.T) {
+	// one degree of latitude is roughly 111.2km
+	lng, lat := destination(0, 0, 111195, 0)
+	assertCloseTo(t, 0, lng, 1e-6)
+	assertCloseTo(t, 1, lat, 1e-3)
+
+	lng, lat = destination(0, 0, 111195, 90)
+	assertCloseTo(t, 1, lng, 1e-3)
+	assertCloseTo(t, 0, lat, 1e-6)
+
+	// crossing the antimeridian wraps the longitude
+	lng, _ = destination(179.9, 0, 111195, 90)
+	assertCloseTo(t, -179.1, lng, 1e-3)
+}
+
+func TestCirclePolygon(t *testing.T) {
+	c := circlePolygon(-122.5, 45.5, 1000, 8)
+	assert.Equal(t, "Polygon", c["type"])
+
+	ring := c["coordinates"].([]interface{})[0].([]interface{})
+	assert.Equal(t, 9, len(ring))
+	assert.Equal(t, ring[0], ring[8])
+
+	// the first vertex is due north of the center
+	first := ring[0].([]interface{})
+	assertCloseTo(t, -122.5, first[0].(float64), 1e-6)
+	assertCloseTo(t, 45.5+1000/111195.0, first[1].(float64), 1e-4)
+
+	// too few segments are raised to a triangle
+	c = circlePolygon(-122.5, 45.5, 1000, 2)
+	ring = c["coordinates"].([]interface{})[0].([]interface{})
+	assert.Equal(t, 4, len(ring))
+}
+
+func TestPointCoords(t *testing.T) {
+	lng, lat, ok := pointCoords(map[string]interface{}{
+		"type":        "Point",
+		"coordinates": []interface{}{float64(1), float64(2)},
+	})
+	assert.Equal(t, true, ok)
+	assert.Equal(t, float64(1), lng)
+	assert.Equal(t, float64(2), lat)
+
+	_, _, ok = pointCoords(map[string]interface{}{
+		"type": "Feature",
+		"geometry": map[string]interface{}{
+			"type":        "Point",
+			"coordinates": []interface{}{float64(1), float64(2)},
+		},
+	})
+	assert.Equal(t, true, ok)
+
+	_, _, ok = pointCoords(map[string]interface{}{
+		"type":        "LineString",
+		"coordinates": []interface{}{},
+	})
+	assert.Equal(t, false, ok)
+}
+
+func TestDistance(t *testing.T) {
+	assert.Equal(t, float64(0), distance(10, 10, 10, 10))
+	assertCloseTo(t, 111195, distance(0, 0, 0, 1), 1)
+	assertCloseTo(t, 111195, distance(0, 0, 1, 0), 1)
+
+	// Portland to Seattle, roughly 234km
+	assertCloseTo(t, 234000, distance(-122.68, 45.52, -122.33, 47.61), 1000)
+}
+
+func TestShapeIntersects(t *testing.T) {
+	square := rectShape(rect{0, 0, 10, 10})
+	withHole := toShape(map[string]interface{}{
+		"type": "Polygon",
+		"coordinates": []interface{}{
+			[]interface{}{
+				[]interface{}{float64(0), float64(0)},
+				[]interface{}{float64(10), float64(0)},
+				[]interface{}{float64(10), float64(10)},
+				[]interface{}{float64(0), float64(10)},
+				[]interface{}{float64(0), float64(0)},
+			},
+			[]interface{}{
+				[]interface{}{float64(4), float64(4)},
+				[]interface{}{float64(6), float64(4)},
+				[]interface{}{float64(6), float64(6)},
+				[]interface{}{float64(4), float64(6)},
+				[]interface{}{float64(4), float64(4)},
+			},
+		},
+	})
+
+	point := func(x, y float64) shape {
+		return shape{points: [][2]float64{{x, y}}}
+	}
+	line := func(coords ...[2]float64) shape {
+		return shape{lines: [][][2]float64{coords}}
+	}
+
+	assert.Equal(t, true, point(5, 5).intersects(square))
+	assert.Equal(t, false, point(5, 5).intersects(withHole))
+	assert.Equal(t, true, point(2, 2).intersects(withHole))
+	assert.Equal(t, false, point(11, 5).intersects(square))
+
+	// a line crossing the square without a vertex inside of it
+	assert.Equal(t, true, line([2]float64{-5, 5}, [2]float64{15, 5}).intersects(square))
+	assert.Equal(t, false, line([2]float64{-5, 15}, [2]float64{15, 15}).intersects(square))
+
+	// overlapping, containing and contained polygons
+	assert.Equal(t, true, rectShape(rect{5, 5, 15, 15}).intersects(square))
+	assert.Equal(t, true, rectShape(rect{-5, -5, 15, 15}).intersects(square))
+	assert.Equal(t, true, rectShape(rect{1, 1, 2, 2}).intersects(square))
+	assert.Equal(t, false, rectShape(rect{20, 20, 30, 30}).intersects(square))
+}
+
+func TestToShape(t *testing.T) {
+	s := toShape(map[string]interface{}{
+		"type": "FeatureCollection",
+		"features": []interface{}{
+			map[string]interface{}{
+				"type": "Feature",
+				"geometry": map[string]interface{}{
+					"type":        "MultiPoint",
+					"coordinates": []interface{}{[]interface{}{float64(1), float64(2)}, []interface{}{float64(3), float64(4)}},
+				},
+			},
+			map[string]interface{}{
+				"type": "Feature",
+				"geometry": map[string]interface{}{
+					"type":        "LineString",
+					"coordinates": []interface{}{[]interface{}{float64(-1), float64(-2)}, []interface{}{float64(5), float64(6)}},
+				},
+			},
+		},
+	})
+
+	assert.Equal(t, 2, len(s.points))
+	assert.Equal(t, 1, len(s.lines))
+	b, ok := s.bounds()
+	assert.Equal(t, true, ok)
+	assert.Equal(t, rect{-1, -2, 5, 6}, b)
+
+	_, ok = toShape(map[string]interface{}{"type": "Unknown"}).bounds()
+	assert.Equal(t, false, ok)
+}
+
+func TestRectIntersects(t *testing.T) {
+	a := rect{0, 0, 10, 10}
+	assert.Equal(t, true, a.intersects(rect{5, 5, 15, 15}))
+	assert.Equal(t, true, a.intersects(rect{10, 10, 15, 15}))
+	assert.Equal(t, true, a.intersects(rect{2, 2, 3, 3}))
+	assert.Equal(t, false, a.intersects(rect{11, 0, 15, 10}))
+	assert.Equal(t, false, a.intersects(rect{0, -5, 10, -1}))
+}
@@ -3,18 +3,22 @@ package main
 import (
 	"encoding/json"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
+	"math"
 	"net/http"
+	"strconv"
 	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/nu7hatch/gouuid"
 	redis "github.com/vmihailenco/redis/v2"
 )
 
-// requests per second
-var limit = 1
+// methods accepted by the web routes, GET for the app and the rest for sending requests to bins
+const binMethods = "GET, HEAD, POST, PUT, PATCH, DELETE, OPTIONS"
 
 // createRouter creates the http.HandleFunc to route requests to the handlers defined below.
 func createRouter() *http.ServeMux {
@@ -23,11 +27,17 @@ func createRouter() *http.ServeMux {
 	// Web routes
 	r.HandleFunc("/", func(w http.ResponseWriter, req *http.Request) {
 		switch req.Method {
-		case "GET":
+		case "GET", "HEAD":
 			debugLog("web -", req.URL)
 			http.ServeFile(w, req, "static/app/index.html")
-		case "POST":
-			rateLimit(binHandler, limit)(w, req)
+		case "POST", "PUT", "PATCH", "DELETE":
+			rateLimit(binHandler, "bin")(w, req)
+		case "OPTIONS":
+			w.Header().Set("Allow", binMethods)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", binMethods)
+			http.Error(w, "Method not allowed.", http.StatusMethodNotAllowed)
 		}
 	})
 	r.HandleFunc("/static/", func(w http.ResponseWriter, req *http.Request) {
@@ -50,16 +60,73 @@ func createRouter() *http.ServeMux {
 	}
 
 	r.HandleFunc("/api/1/counts", apiRoute(countsHandler))
-	r.HandleFunc("/api/1/create", apiRoute(rateLimit(createHandler, limit)))
-	r.HandleFunc("/api/1/history/", apiRoute(rateLimit(historyHandler, limit))) // /api/1/history/{bin_id}
-	r.HandleFunc("/api/1/ws/", wsHandler)                                       // /api/1/ws/{bin_id}
+	r.HandleFunc("/api/1/create", apiRoute(rateLimit(createHandler, "create")))
+	r.HandleFunc("/api/1/accounts", apiRoute(rateLimit(accountsHandler, "accounts")))
+	r.HandleFunc("/api/1/login", apiRoute(rateLimit(loginHandler, "login")))
+	r.HandleFunc("/api/1/account/bins", apiRoute(accountBinsHandler))
+	r.HandleFunc("/api/1/bans", apiRoute(bansHandler))
+	r.HandleFunc("/api/1/stats", apiRoute(statsHandler))
+	r.HandleFunc("/api/1/history/", apiRoute(rateLimit(historyHandler, "history"))) // /api/1/history/{bin_id}
+	r.HandleFunc("/api/1/ws", wsMuxHandler)                                         // /api/1/ws
+	r.HandleFunc("/api/1/ws/", wsHandler)                                           // /api/1/ws/{bin_id}
+	r.HandleFunc("/api/1/sse/", sseHandler)                                         // /api/1/sse/{bin_id}
+	r.HandleFunc("/api/1/poll/", apiRoute(pollHandler))                             // /api/1/poll/{bin_id}
+	r.HandleFunc("/api/1/bins/", apiRoute(rateLimit(binsHandler, "bins")))          // /api/1/bins/{bin_id}/{action}
 
 	return r
 }
 
+// binActions maps the {action} part of /api/1/bins/{bin_id}/{action} routes to their handlers.
+// Each handler is called with the bin_id after it has been checked to exist.
+var binActions = map[string]func(http.ResponseWriter, *http.Request, string){
+	"tracks":    tracksHandler,
+	"query":     queryHandler,
+	"fences":    fencesHandler,
+	"forwards":  forwardsHandler,
+	"mocks":     mocksHandler,
+	"replay":    replayHandler,
+	"replays":   replaysHandler,
+	"signature": signatureHandler,
+	"redaction": redactionHandler,
+	"extend":    extendHandler,
+	"delete":    deleteHandler,
+	"share":     shareHandler,
+	"links":     linksHandler,
+	"pin":       pinHandler,
+	"purge":     purgeHandler,
+	"cap":       capHandler,
+	"info":      infoHandler,
+}
+
+// binActionLevels lists the binActions that need more than the read token of a private bin. The
+// others need readAccess, which share links don't have.
+var binActionLevels = map[string]accessLevel{
+	"fences":    writeAccess,
+	"forwards":  writeAccess,
+	"mocks":     writeAccess,
+	"replay":    writeAccess,
+	"signature": writeAccess,
+	"redaction": writeAccess,
+	"extend":    writeAccess,
+	"delete":    writeAccess,
+	"share":     ownerAccess,
+	"links":     writeAccess,
+	"pin":       adminAccess,
+	"purge":     writeAccess,
+	"cap":       writeAccess,
+}
+
+// CreateOptions are the options that may be sent to /api/1/create.
+type CreateOptions struct {
+	// create a private bin, which can only be used with the tokens returned when it is created
+	Private bool `json:"private"`
+	// seconds the bin lives for, within the limits of the config, 0 for the default
+	TTL int64 `json:"ttl"`
+}
+
 // createHandler handles requests to /api/1/create. It creates a randomly generated bin_id,
-// creates an entry in redis for it, with a 48 hour expiration time and writes a json object
-// to the response with the following structure:
+// creates an entry in redis for it, with an expiration time (48 hours by default) and writes a
+// json object to the response with the following structure:
 //
 // `{
 //    "id": {bin_id},
@@ -67,9 +134,49 @@ func createRouter() *http.ServeMux {
 // }`
 //
 // The expiration timestamp is in Unix time (milis).
+//
+// If the request body is a JSON object of CreateOptions asking for a private bin, the response
+// also holds the bin's "readToken" and "writeToken".
+//
+// If the request is sent with the API key of an account, the bin is owned by that account.
 func createHandler(w http.ResponseWriter, r *http.Request) {
 	debugLog("create -", r.URL)
 
+	owner, err := accountForKey(requestToken(r))
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if owner == nil && requestToken(r) != "" && !isAdmin(requestToken(r)) {
+		http.Error(w, "Invalid API key.", http.StatusUnauthorized)
+		return
+	}
+
+	var opts CreateOptions
+	if _, err := decodeOptionalBody(r, &opts); err != nil {
+		log.Println("Error unmarshalling create options:", err)
+		http.Error(w, "Invalid options.", http.StatusBadRequest)
+		return
+	}
+	d, err := binTTL(opts.TTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if !isAdmin(requestToken(r)) {
+		allowed, retryAfter, err := countBinCreation(time.Now())
+		if err != nil {
+			http.Error(w, "Could not generate new Geobin!", http.StatusInternalServerError)
+			return
+		}
+		if !allowed {
+			w.Header().Set("Retry-After", fmt.Sprint(int64(math.Ceil(retryAfter.Seconds()))))
+			http.Error(w, "Too many bins have been created lately. Try again later.", http.StatusTooManyRequests)
+			return
+		}
+	}
+
 	// Get a new name
 	n, err := randomString(config.NameLength)
 	if err != nil {
@@ -86,7 +193,6 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Set expiration
-	d := 48 * time.Hour
 	if res := client.Expire(n, d); res.Err() != nil {
 		log.Println("Failure to set EXPIRE for", n, res.Err())
 		http.Error(w, "Could not generate new Geobin!", http.StatusInternalServerError)
@@ -101,6 +207,29 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 		"expires": exp,
 	}
 
+	if opts.Private {
+		ba, err := newBinAccess()
+		if err != nil {
+			log.Println("Failure to generate tokens for", n, err)
+			http.Error(w, "Could not generate new Geobin!", http.StatusInternalServerError)
+			return
+		}
+		if err := setBinSetting(n, accessSetting, ba); err != nil {
+			http.Error(w, "Could not generate new Geobin!", http.StatusInternalServerError)
+			return
+		}
+		bin["readToken"] = ba.ReadToken
+		bin["writeToken"] = ba.WriteToken
+	}
+
+	if owner != nil {
+		if err := ownBin(n, owner.Username); err != nil {
+			http.Error(w, "Could not generate new Geobin!", http.StatusInternalServerError)
+			return
+		}
+		bin["owner"] = owner.Username
+	}
+
 	// encode the json directly to the response writer
 	err = encoder.Encode(bin)
 	if err != nil {
@@ -110,10 +239,188 @@ func createHandler(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// Credentials are sent to /api/1/accounts and /api/1/login.
+type Credentials struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+}
+
+// accountsHandler handles requests to /api/1/accounts. It creates an account from the JSON
+// Credentials in the request body and responds with it, including its API key, e.g.:
+//
+// `{
+//    "username": "alice",
+//    "apiKey": "5c1e0d8f3b2a4e6f9a7b8c0d1e2f3a4b",
+//    "created": 1400539133
+// }`
+//
+// The password is optional, accounts without one can only be used with their API key.
+func accountsHandler(w http.ResponseWriter, r *http.Request) {
+	var c Credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		log.Println("Error unmarshalling credentials:", err)
+		http.Error(w, "Invalid credentials.", http.StatusBadRequest)
+		return
+	}
+
+	a, err := createAccount(c.Username, c.Password)
+	if err == errAccountExists {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	writeAccount(w, a)
+}
+
+// loginHandler handles requests to /api/1/login. It responds with the account matching the JSON
+// Credentials in the request body, in the same format as accountsHandler, so that the API key can
+// be picked up on another device.
+func loginHandler(w http.ResponseWriter, r *http.Request) {
+	var c Credentials
+	if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+		log.Println("Error unmarshalling credentials:", err)
+		http.Error(w, "Invalid credentials.", http.StatusBadRequest)
+		return
+	}
+
+	a, err := login(c.Username, c.Password)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if a == nil {
+		http.Error(w, "Wrong username or password.", http.StatusUnauthorized)
+		return
+	}
+
+	writeAccount(w, a)
+}
+
+// writeAccount writes a to the response, leaving out its password hash.
+func writeAccount(w http.ResponseWriter, a *Account) {
+	a.Password = ""
+	if err := json.NewEncoder(w).Encode(a); err != nil {
+		log.Println("Error marshalling account:", err)
+		http.Error(w, "Could not get account.", http.StatusInternalServerError)
+	}
+}
+
+// accountBinsHandler handles requests to /api/1/account/bins. It responds with an array of the
+// AccountBins owned by, or shared with, the account whose API key is sent with the request.
+func accountBinsHandler(w http.ResponseWriter, r *http.Request) {
+	a, err := accountForKey(requestToken(r))
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	if a == nil {
+		http.Error(w, "An API key is required.", http.StatusUnauthorized)
+		return
+	}
+
+	bins, err := getAccountBins(a.Username)
+	if err != nil {
+		http.Error(w, "Could not get bins.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(bins); err != nil {
+		log.Println("Error marshalling bins:", err)
+		http.Error(w, "Could not get bins.", http.StatusInternalServerError)
+	}
+}
+
+// bansHandler handles requests to /api/1/bans, which need the admin key. It responds with the
+// client IPs that are banned from the rate limited routes, e.g.
+//
+// `[
+//    {
+//      "ip": "203.0.113.7",
+//      "reason": "went over the create rate limit 20 times within 1m0s",
+//      "created": 1400539133,
+//      "expires": 1400542733
+//    }
+// ]`
+//
+// A BanRequest in the request body lifts bans first, e.g. `{ "clear": "203.0.113.7" }` or
+// `{ "clearAll": true }`.
+func bansHandler(w http.ResponseWriter, r *http.Request) {
+	token := requestToken(r)
+	if token == "" {
+		http.Error(w, "The admin key is needed.", http.StatusUnauthorized)
+		return
+	}
+	if !isAdmin(token) {
+		http.Error(w, "The admin key is needed.", http.StatusForbidden)
+		return
+	}
+
+	var br BanRequest
+	updated, err := decodeOptionalBody(r, &br)
+	if err != nil {
+		log.Println("Error unmarshalling ban request:", err)
+		http.Error(w, "Invalid ban request.", http.StatusBadRequest)
+		return
+	}
+	if updated {
+		if err := br.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := clearBans(br); err != nil {
+			http.Error(w, "Could not clear bans.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	bans, err := getBans(time.Now())
+	if err != nil {
+		http.Error(w, "Could not get bans.", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(bans); err != nil {
+		log.Println("Error marshalling bans:", err)
+		http.Error(w, "Could not get bans.", http.StatusInternalServerError)
+	}
+}
+
+// ServerStats describes the running server.
+type ServerStats struct {
+	// messages dropped by sockets whose clients couldn't keep up, since the server started
+	DroppedMessages uint64 `json:"droppedMessages"`
+}
+
+// statsHandler handles requests to /api/1/stats, which need the admin key. It responds with the
+// ServerStats of the server handling the request, e.g. `{ "droppedMessages": 12 }`.
+func statsHandler(w http.ResponseWriter, r *http.Request) {
+	token := requestToken(r)
+	if token == "" {
+		http.Error(w, "The admin key is needed.", http.StatusUnauthorized)
+		return
+	}
+	if !isAdmin(token) {
+		http.Error(w, "The admin key is needed.", http.StatusForbidden)
+		return
+	}
+
+	stats := ServerStats{DroppedMessages: atomic.LoadUint64(&droppedMessages)}
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		log.Println("Error marshalling stats:", err)
+		http.Error(w, "Could not get stats.", http.StatusInternalServerError)
+	}
+}
+
 // countsHandler handles requests to /api/1/counts. It requires an array of binIds as input
 // and responds with a dictionary with the binIds as the key and the number of requests stored
 // in the db for that binId. If a binId is not found in the db, the value for that binId in the
 // response will be null.
+//
+// With a `viewers` query parameter of true, each value is instead an object holding both the
+// number of requests and the number of viewers watching the bin across every server.
 func countsHandler(w http.ResponseWriter, r *http.Request) {
 	debugLog("counts -", r.URL)
 
@@ -125,14 +432,35 @@ func countsHandler(w http.ResponseWriter, r *http.Request) {
 		http.Error(w, "Error marshalling request:", http.StatusBadRequest)
 	}
 
+	withViewers := r.URL.Query().Get("viewers") == "true"
+	token := requestToken(r)
+
 	// look up each binId in db
 	counts := make(map[string]interface{})
 	for _, binId := range binIds {
-		if c, err := client.ZCount(binId, "-inf", "+inf").Result(); err == nil && c > 0 {
-			counts[binId] = c - 1
-		} else {
+		c, err := client.ZCount(binId, "-inf", "+inf").Result()
+		if err != nil || c == 0 {
+			counts[binId] = nil
+			continue
+		}
+
+		// private bins are left out unless the request has their token
+		if ok, err := checkAccess(binId, token, readAccess); err != nil || !ok {
 			counts[binId] = nil
+			continue
+		}
+
+		if !withViewers {
+			counts[binId] = c - 1
+			continue
+		}
+
+		viewers, err := countViewers(binId)
+		if err != nil {
+			http.Error(w, "Could not count viewers.", http.StatusInternalServerError)
+			return
 		}
+		counts[binId] = map[string]int64{"requests": c - 1, "viewers": viewers}
 	}
 
 	// return counts
@@ -145,9 +473,14 @@ func countsHandler(w http.ResponseWriter, r *http.Request) {
 // binHandler handles requests to /api/1/{binId}. It requires a binId in the request path and some
 // JSON in the POST body. It creates a new GeobinRequest object using the body, which in turn
 // searches for any geo data in said JSON. It then adds the hydrated GeobinRequest to the database.
+// Anything in the path after the binId is only used to pick which of the bin's mock responses, if
+// any, is written to the response.
 func binHandler(w http.ResponseWriter, r *http.Request) {
 	debugLog("bin -", r.URL)
-	name := r.URL.Path[1:]
+	name, subpath := r.URL.Path[1:], "/"
+	if i := strings.Index(name, "/"); i >= 0 {
+		name, subpath = name[:i], name[i:]
+	}
 
 	exists, err := nameExists(name)
 	if err != nil {
@@ -160,6 +493,10 @@ func binHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, name, writeAccess) {
+		return
+	}
+
 	var body []byte
 	if r.Body != nil {
 		// Limit reading of the request body to the first 1MB (1<<20 bytes)
@@ -176,21 +513,124 @@ func binHandler(w http.ResponseWriter, r *http.Request) {
 	for k, v := range r.Header {
 		headers[k] = strings.Join(v, ", ")
 	}
+	// the bin's token is never stored, whatever the redaction rules say
+	stripToken(r, headers)
+
+	// signatures are checked against, and forward targets sent, the request as it was sent, only
+	// the stored copy is redacted
+	raw := &GeobinRequest{Method: r.Method, Headers: make(map[string]string, len(headers)), Body: string(body)}
+	for k, v := range headers {
+		raw.Headers[k] = v
+	}
+	rd, err := redactorFor(name)
+	if err != nil {
+		log.Println("Failure to get redaction rules for", name, err)
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+	redactedBody, redacted := rd.redact(headers, body)
+
+	now := time.Now().UTC()
+	gr := NewGeobinRequest(now.Unix(), headers, redactedBody)
+	gr.Method = r.Method
+	if len(redacted) > 0 {
+		gr.Redacted = redacted
+	}
+
+	ss, err := getSignatureSettings(name)
+	if err != nil {
+		log.Println("Failure to get signature settings for", name, err)
+	}
+	if ss != nil {
+		gr.Signature = ss.verify(r.Header, body, now)
+		if ss.Reject && !gr.Signature.Valid {
+			// keep the rejected request, so that it can be seen why it was rejected
+			if _, err := storeRequest(name, gr); err != nil {
+				log.Println("Failure to store rejected request for", name, err)
+			}
+			http.Error(w, "Invalid signature: "+gr.Signature.Reason, http.StatusUnauthorized)
+			return
+		}
+	}
+
+	// requests forwarded by a bin aren't forwarded again, which could go on forever
+	member, err := storeRequest(name, gr)
+	if err != nil {
+		log.Println("Failure to store request for", name, err)
+	} else if r.Header.Get(forwardedHeader) == "" {
+		go forwardRequest(name, gr, raw, member)
+	}
+
+	events, err := evaluateFences(name, gr)
+	if err != nil {
+		log.Println("Failure to evaluate fences for", name, err)
+	}
+	for _, ev := range events {
+		if _, err := storeRequest(name, ev); err != nil {
+			log.Println("Failure to store fence event for", name, err)
+		}
+	}
+
+	rule, err := findMock(name, gr, subpath)
+	if err != nil {
+		log.Println("Failure to get mock response for", name, err)
+	}
+	if rule != nil {
+		writeMock(w, rule, name, subpath, gr)
+	}
+}
 
-	gr := NewGeobinRequest(time.Now().UTC().Unix(), headers, body)
+// storeRequest adds the given GeobinRequest to the history of the given bin and publishes it to
+// anyone listening to the bin, giving it an ID if it doesn't have one. If the bin goes over its
+// cap, its oldest requests are evicted. It returns the member of the bin's sorted set that was
+// stored, and only fails if the request couldn't be stored, not if it couldn't be published.
+func storeRequest(name string, gr *GeobinRequest) (string, error) {
+	if gr.ID == "" {
+		gr.ID = newRequestID(gr.Timestamp)
+	}
 
 	encoded, err := json.Marshal(gr)
 	if err != nil {
 		log.Println("Error marshalling request:", err)
+		return "", err
 	}
 
-	if res := client.ZAdd(name, redis.Z{Score: float64(time.Now().UTC().Unix()), Member: string(encoded)}); res.Err() != nil {
-		log.Println("Failure to ZADD to", name, res.Err())
+	if _, err := addCapped(name, gr.Timestamp, string(encoded)); err != nil {
+		return "", err
 	}
 
+	// the request is stored whether or not anyone listening could be told about it
 	if res := client.Publish(name, string(encoded)); res.Err() != nil {
 		log.Println("Failure to PUBLISH to", name, res.Err())
 	}
+
+	return string(encoded), nil
+}
+
+// replaces a member of a sorted set only if it is still there, so that we don't bring back
+// requests that were removed in the meantime
+var replaceMemberScript = `
+if redis.call('ZREM', KEYS[1], ARGV[1]) == 1 then
+	redis.call('ZADD', KEYS[1], ARGV[2], ARGV[3])
+	return 1
+end
+return 0`
+
+// replaceRequest replaces the member of the given bin's history stored by storeRequest with the
+// current contents of gr.
+func replaceRequest(name, member string, gr *GeobinRequest) error {
+	encoded, err := json.Marshal(gr)
+	if err != nil {
+		log.Println("Error marshalling request:", err)
+		return err
+	}
+
+	score := fmt.Sprint(gr.Timestamp)
+	if res := client.Eval(replaceMemberScript, []string{name}, []string{member, score, string(encoded)}); res.Err() != nil {
+		log.Println("Failure to replace request in", name, res.Err())
+		return res.Err()
+	}
+	return nil
 }
 
 // historyHandler handles requests to /api/v1/history/{bin_id}. It requires a bin_id in the
@@ -212,73 +652,1191 @@ func historyHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if !authorize(w, r, name, viewAccess) {
+		return
+	}
+
+	link, err := getLink(name, requestToken(r))
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	history, err := getHistory(name)
+	if err != nil {
+		http.Error(w, "Could not generate history.", http.StatusInternalServerError)
+		return
+	}
+	if link != nil {
+		history = link.filterWindow(history)
+	}
+
+	encoder := json.NewEncoder(w)
+	err = encoder.Encode(history)
+	if err != nil {
+		log.Println("Error marshalling request history:", err)
+		http.Error(w, "Could not generate history.", http.StatusInternalServerError)
+		return
+	}
+}
+
+// getHistory returns all of the GeobinRequests stored for the given bin_id, newest first.
+func getHistory(name string) ([]*GeobinRequest, error) {
 	set := client.ZRevRange(name, "0", "-1")
 	if set.Err() != nil {
 		log.Println("Failure to ZREVRANGE for", name, set.Err())
+		return nil, set.Err()
 	}
 
 	// chop off the last history member since it is the placeholder value from when the set was created
-	vals := set.Val()[:len(set.Val())-1]
+	vals := set.Val()
+	if len(vals) > 0 {
+		vals = vals[:len(vals)-1]
+	}
 
-	history := make([]GeobinRequest, 0, len(vals))
+	history := make([]*GeobinRequest, 0, len(vals))
 	for _, v := range vals {
 		var gr GeobinRequest
 		if err := json.Unmarshal([]byte(v), &gr); err != nil {
 			log.Println("Error unmarshalling request history:", err)
 		}
-		history = append(history, gr)
+		history = append(history, &gr)
 	}
 
-	encoder := json.NewEncoder(w)
-	err = encoder.Encode(history)
-	if err != nil {
-		log.Println("Error marshalling request history:", err)
-		http.Error(w, "Could not generate history.", http.StatusInternalServerError)
-		return
-	}
+	return history, nil
 }
 
-// wsHandler handles requests to /api/1/ws/{bin_id}. It requires a bin_id in the request path
-// and it subscribes to listen for changes to the bin_id in redis. It creates a socket with
-// a UUID and adds that socket to the socketMap. It then sends any updates to the bin_id in
-// redis to the socket as they come in.
-func wsHandler(w http.ResponseWriter, r *http.Request) {
-	debugLog("create -", r.URL)
-	path := strings.Split(r.URL.Path, "/")
-	binName := path[len(path)-1]
-
-	// start pub subbing
-	if err := pubsub.Subscribe(binName); err != nil {
-		log.Println("Failure to SUBSCRIBE to", binName, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+// getHistorySince returns the stored requests of the given bin received at or after the given Unix
+// timestamp, oldest first. If afterID is the ID of a request, requests stored before and including
+// it are left out, so a client that has seen it gets only what it missed.
+func getHistorySince(name string, since int64, afterID string) ([]*GeobinRequest, error) {
+	afterTS, afterCreated, ok := parseRequestID(afterID)
+	if ok && afterTS > since {
+		since = afterTS
 	}
 
-	id, err := uuid.NewV4()
-	if err != nil {
-		log.Println("Failure to generate new socket UUID", binName, err)
-		http.Error(w, "Internal server error", http.StatusInternalServerError)
-		return
+	set := client.ZRangeByScore(name, redis.ZRangeByScore{Min: fmt.Sprint(since), Max: "+inf"})
+	if set.Err() != nil {
+		log.Println("Failure to ZRANGEBYSCORE for", name, set.Err())
+		return nil, set.Err()
 	}
-	uuid := id.String()
 
-	s, err := NewSocket(binName+"~br~"+uuid, w, r, nil, func(socketName string) {
-		// the socketname is a composite of the bin name, and the socket UUID
-		ids := strings.Split(socketName, "~br~")
-		bn := ids[0]
-		suuid := ids[1]
-		if err := socketMap.Delete(bn, suuid); err != nil {
-			log.Println(err)
+	history := make([]*GeobinRequest, 0, len(set.Val()))
+	for _, v := range set.Val() {
+		// skip the placeholder value from when the set was created
+		if v == "" {
+			continue
 		}
-	})
 
-	if err != nil {
-		// if there is an error, NewSocket will have already written a response via http.Error()
-		// so only write a log
-		log.Println("Error opening websocket:", err)
-		return
+		var gr GeobinRequest
+		if err := json.Unmarshal([]byte(v), &gr); err != nil {
+			log.Println("Error unmarshalling request history:", err)
+			continue
+		}
+
+		if ok && gr.Timestamp == afterTS {
+			if _, created, valid := parseRequestID(gr.ID); !valid || created <= afterCreated {
+				continue
+			}
+		}
+		history = append(history, &gr)
+	}
+
+	return history, nil
+}
+
+// findRequest looks up the GeobinRequest with the given ID in the history of the given bin. It
+// returns the request and the member of the bin's sorted set it is stored as, or nil if there is
+// no such request.
+func findRequest(name, id string) (*GeobinRequest, string, error) {
+	ts, _, ok := parseRequestID(id)
+	if !ok {
+		return nil, "", nil
+	}
+
+	score := fmt.Sprint(ts)
+	set := client.ZRangeByScore(name, redis.ZRangeByScore{Min: score, Max: score})
+	if set.Err() != nil {
+		log.Println("Failure to ZRANGEBYSCORE for", name, set.Err())
+		return nil, "", set.Err()
 	}
 
-	// keep track of the outbound channel for pubsubbery
-	socketMap.Add(binName, uuid, s)
+	for _, v := range set.Val() {
+		var gr GeobinRequest
+		if err := json.Unmarshal([]byte(v), &gr); err != nil {
+			log.Println("Error unmarshalling request history:", err)
+			continue
+		}
+		if gr.ID == id {
+			return &gr, v, nil
+		}
+	}
+
+	return nil, "", nil
+}
+
+// binsHandler handles requests to /api/1/bins/{bin_id}/{action}. It requires a bin_id that exists
+// and an action listed in binActions, which it hands the request off to.
+func binsHandler(w http.ResponseWriter, r *http.Request) {
+	debugLog("bins -", r.URL)
+	path := strings.Split(strings.Trim(strings.TrimPrefix(r.URL.Path, "/api/1/bins/"), "/"), "/")
+	if len(path) != 2 {
+		http.NotFound(w, r)
+		return
+	}
+	name, action := path[0], path[1]
+
+	h, ok := binActions[action]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	exists, err := nameExists(name)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	level, ok := binActionLevels[action]
+	if !ok {
+		level = readAccess
+	}
+	if !authorize(w, r, name, level) {
+		return
+	}
+
+	h(w, r, name)
+}
+
+// tracksHandler handles requests to /api/1/bins/{bin_id}/tracks. It assembles the points stored in
+// the bin into per-device tracks and writes them to the response as a GeoJSON FeatureCollection.
+// The request body may hold a JSON object of TrackOptions, e.g.:
+//
+// `{
+//    "deviceKey": "vehicle.id",
+//    "order": "device",
+//    "dwellRadius": 25,
+//    "dwellTime": 60
+// }`
+func tracksHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var opts TrackOptions
+	if _, err := decodeOptionalBody(r, &opts); err != nil {
+		log.Println("Error unmarshalling track options:", err)
+		http.Error(w, "Invalid track options.", http.StatusBadRequest)
+		return
+	}
+	if err := opts.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	history, err := getHistory(name)
+	if err != nil {
+		http.Error(w, "Could not generate tracks.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(assembleTracks(history, opts)); err != nil {
+		log.Println("Error marshalling tracks:", err)
+		http.Error(w, "Could not generate tracks.", http.StatusInternalServerError)
+	}
+}
+
+// queryHandler handles requests to /api/1/bins/{bin_id}/query. It requires a SpatialQuery in the
+// request body and writes the stored requests with geo data matching it to the response as a JSON
+// array of QueryResults, e.g.:
+//
+// `{ "bbox": [-123, 45, -122, 46] }`
+// `{ "point": [-122.5, 45.5], "radius": 500 }`
+// `{ "polygon": { "type": "Polygon", "coordinates": [ ... ] } }`
+func queryHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var q SpatialQuery
+	if r.Body == nil {
+		http.Error(w, "A query is required.", http.StatusBadRequest)
+		return
+	}
+	if err := json.NewDecoder(r.Body).Decode(&q); err != nil {
+		log.Println("Error unmarshalling spatial query:", err)
+		http.Error(w, "Invalid query.", http.StatusBadRequest)
+		return
+	}
+
+	history, err := getHistory(name)
+	if err != nil {
+		http.Error(w, "Could not run query.", http.StatusInternalServerError)
+		return
+	}
+
+	results, err := queryHistory(history, q)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(results); err != nil {
+		log.Println("Error marshalling query results:", err)
+		http.Error(w, "Could not run query.", http.StatusInternalServerError)
+	}
+}
+
+// fencesHandler handles requests to /api/1/bins/{bin_id}/fences. If the request has a body, it
+// must be a JSON object of FenceSettings, which replaces the fences defined for the bin. The
+// current FenceSettings for the bin are written to the response, e.g.:
+//
+// `{
+//    "deviceKey": "vehicle.id",
+//    "fences": [
+//      { "name": "warehouse", "point": [-122.5, 45.5], "radius": 100 },
+//      { "name": "downtown", "polygon": { "type": "Polygon", "coordinates": [ ... ] } }
+//    ]
+// }`
+func fencesHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var fs FenceSettings
+	updated, err := decodeOptionalBody(r, &fs)
+	if err != nil {
+		log.Println("Error unmarshalling fences:", err)
+		http.Error(w, "Invalid fences.", http.StatusBadRequest)
+		return
+	}
+
+	if updated {
+		if err := fs.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setFences(name, fs); err != nil {
+			http.Error(w, "Could not save fences.", http.StatusInternalServerError)
+			return
+		}
+	} else if fs, err = getFences(name); err != nil {
+		http.Error(w, "Could not get fences.", http.StatusInternalServerError)
+		return
+	}
+
+	if fs.Fences == nil {
+		fs.Fences = make([]Fence, 0)
+	}
+	if err := json.NewEncoder(w).Encode(fs); err != nil {
+		log.Println("Error marshalling fences:", err)
+		http.Error(w, "Could not get fences.", http.StatusInternalServerError)
+	}
+}
+
+// forwardsHandler handles requests to /api/1/bins/{bin_id}/forwards. If the request has a body,
+// it must be a JSON object of ForwardSettings, which replaces the forward targets for the bin.
+// The current ForwardSettings for the bin are written to the response, e.g.:
+//
+// `{
+//    "targets": [
+//      { "url": "https://example.com/webhook", "headers": { "Authorization": "Bearer abc" } }
+//    ],
+//    "retries": 3
+// }`
+func forwardsHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var fs ForwardSettings
+	updated, err := decodeOptionalBody(r, &fs)
+	if err != nil {
+		log.Println("Error unmarshalling forwards:", err)
+		http.Error(w, "Invalid forwards.", http.StatusBadRequest)
+		return
+	}
+
+	if updated {
+		if err := fs.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setBinSetting(name, forwardsSetting, fs); err != nil {
+			http.Error(w, "Could not save forwards.", http.StatusInternalServerError)
+			return
+		}
+	} else if fs, err = getForwards(name); err != nil {
+		http.Error(w, "Could not get forwards.", http.StatusInternalServerError)
+		return
+	}
+
+	if fs.Targets == nil {
+		fs.Targets = make([]ForwardTarget, 0)
+	}
+	if err := json.NewEncoder(w).Encode(fs); err != nil {
+		log.Println("Error marshalling forwards:", err)
+		http.Error(w, "Could not get forwards.", http.StatusInternalServerError)
+	}
+}
+
+// mocksHandler handles requests to /api/1/bins/{bin_id}/mocks. If the request has a body, it
+// must be a JSON object of MockSettings, which replaces the mock responses defined for the bin,
+// e.g.:
+//
+// `{
+//    "rules": [
+//      { "match": { "path": "/orders/*" }, "status": 201, "body": "{\"id\": \"{{.ID}}\"}" },
+//      { "delay": 2000, "failureRate": 10 }
+//    ]
+// }`
+//
+// The current MockSettings for the bin are written to the response.
+func mocksHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var ms MockSettings
+	updated, err := decodeOptionalBody(r, &ms)
+	if err != nil {
+		log.Println("Error unmarshalling mocks:", err)
+		http.Error(w, "Invalid mocks.", http.StatusBadRequest)
+		return
+	}
+
+	if updated {
+		if err := ms.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setBinSetting(name, mocksSetting, ms); err != nil {
+			http.Error(w, "Could not save mocks.", http.StatusInternalServerError)
+			return
+		}
+	} else if ms, err = getMocks(name); err != nil {
+		http.Error(w, "Could not get mocks.", http.StatusInternalServerError)
+		return
+	}
+
+	if ms.Rules == nil {
+		ms.Rules = make([]MockRule, 0)
+	}
+	if err := json.NewEncoder(w).Encode(ms); err != nil {
+		log.Println("Error marshalling mocks:", err)
+		http.Error(w, "Could not get mocks.", http.StatusInternalServerError)
+	}
+}
+
+// signatureHandler handles requests to /api/1/bins/{bin_id}/signature. With a JSON object of
+// SignatureSettings in the request body, it makes the bin check the signature of every request
+// sent to it, e.g.:
+//
+// `{
+//    "scheme": "github",
+//    "secret": "s3cr3t",
+//    "reject": true
+// }`
+//
+// The result is recorded in the "signature" field of each request. Sending `null` stops checking
+// signatures. With an empty body, it responds with the current settings, or null. The secret is
+// never sent back, only masked, and posting the masked secret keeps the stored one.
+func signatureHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var ss *SignatureSettings
+	updated, err := decodeOptionalBody(r, &ss)
+	if err != nil {
+		log.Println("Error unmarshalling signature settings:", err)
+		http.Error(w, "Invalid signature settings.", http.StatusBadRequest)
+		return
+	}
+
+	// settings read back and posted again keep the stored secret
+	if updated && ss != nil && ss.Secret == maskedSecret {
+		stored, err := getSignatureSettings(name)
+		if err != nil {
+			http.Error(w, "Could not get signature settings.", http.StatusInternalServerError)
+			return
+		}
+		if stored == nil {
+			http.Error(w, "secret is required.", http.StatusBadRequest)
+			return
+		}
+		ss.Secret = stored.Secret
+	}
+
+	if updated {
+		if ss == nil {
+			err = client.HDel(settingsKey(name), signatureSetting).Err()
+		} else if err = ss.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else {
+			err = setBinSetting(name, signatureSetting, ss)
+		}
+		if err != nil {
+			log.Println("Failure to save signature settings for", name, err)
+			http.Error(w, "Could not save signature settings.", http.StatusInternalServerError)
+			return
+		}
+	} else if ss, err = getSignatureSettings(name); err != nil {
+		http.Error(w, "Could not get signature settings.", http.StatusInternalServerError)
+		return
+	}
+
+	if ss != nil {
+		masked := *ss
+		masked.Secret = maskedSecret
+		ss = &masked
+	}
+	if err := json.NewEncoder(w).Encode(ss); err != nil {
+		log.Println("Error marshalling signature settings:", err)
+		http.Error(w, "Could not get signature settings.", http.StatusInternalServerError)
+	}
+}
+
+// redactionHandler handles requests to /api/1/bins/{bin_id}/redaction. It sets the bin's own
+// RedactionRules, applied along with the config's to every request sent to the bin before it is
+// stored or published, e.g.
+//
+// `{
+//    "headers": ["X-Api-Key"],
+//    "query": ["password"],
+//    "paths": ["$.user.email", "$.cards[0].number"],
+//    "patterns": ["\\b\\d{3}-\\d{2}-\\d{4}\\b"]
+// }`
+//
+// Where values were removed is recorded in the "redacted" field of each request. Forward targets
+// are sent requests as they were received, while replays send the redacted stored copy. Sending
+// `null` removes the bin's rules. With an empty body, it responds with the current rules, or null.
+func redactionHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var rr *RedactionRules
+	updated, err := decodeOptionalBody(r, &rr)
+	if err != nil {
+		log.Println("Error unmarshalling redaction rules:", err)
+		http.Error(w, "Invalid redaction rules.", http.StatusBadRequest)
+		return
+	}
+
+	if updated {
+		if rr == nil {
+			err = client.HDel(settingsKey(name), redactionSetting).Err()
+		} else if err = rr.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		} else {
+			err = setBinSetting(name, redactionSetting, rr)
+		}
+		if err != nil {
+			log.Println("Failure to save redaction rules for", name, err)
+			http.Error(w, "Could not save redaction rules.", http.StatusInternalServerError)
+			return
+		}
+	} else if rr, err = getRedactionRules(name); err != nil {
+		http.Error(w, "Could not get redaction rules.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(rr); err != nil {
+		log.Println("Error marshalling redaction rules:", err)
+		http.Error(w, "Could not get redaction rules.", http.StatusInternalServerError)
+	}
+}
+
+// ExtendOptions may be sent to /api/1/bins/{bin_id}/extend.
+type ExtendOptions struct {
+	// seconds from now the bin expires after, within the limits of the config, 0 for the default
+	TTL int64 `json:"ttl"`
+}
+
+// extendHandler handles requests to /api/1/bins/{bin_id}/extend. It renews the bin, so that it
+// expires the number of seconds given by the ExtendOptions in the request body from now, or 48
+// hours from now by default, and responds with its id and new expiration timestamp. Pinned bins
+// can't be extended, as they never expire.
+func extendHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var opts ExtendOptions
+	if _, err := decodeOptionalBody(r, &opts); err != nil {
+		log.Println("Error unmarshalling extend options:", err)
+		http.Error(w, "Invalid options.", http.StatusBadRequest)
+		return
+	}
+	d, err := binTTL(opts.TTL)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	pinned, err := isPinned(name)
+	if err != nil {
+		http.Error(w, "Could not extend bin.", http.StatusInternalServerError)
+		return
+	}
+	if pinned {
+		http.Error(w, "This bin is pinned and never expires.", http.StatusConflict)
+		return
+	}
+
+	if err := extendBin(name, d); err != nil {
+		http.Error(w, "Could not extend bin.", http.StatusInternalServerError)
+		return
+	}
+
+	bin := map[string]interface{}{
+		"id":      name,
+		"expires": time.Now().Add(d).Unix(),
+	}
+	if err := json.NewEncoder(w).Encode(bin); err != nil {
+		log.Println("Error marshalling bin:", err)
+		http.Error(w, "Could not extend bin.", http.StatusInternalServerError)
+	}
+}
+
+// PinOptions are sent to /api/1/bins/{bin_id}/pin.
+type PinOptions struct {
+	Pinned bool `json:"pinned"`
+}
+
+// pinHandler handles requests to /api/1/bins/{bin_id}/pin. With a JSON object of PinOptions in the
+// request body, it makes the bin never expire, or expire after the default lifetime again. It
+// responds with the bin's id, whether it is pinned and its expiration timestamp, which is 0 for
+// pinned bins. Only the config's AdminKey may pin bins.
+func pinHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var opts PinOptions
+	updated, err := decodeOptionalBody(r, &opts)
+	if err != nil {
+		log.Println("Error unmarshalling pin options:", err)
+		http.Error(w, "Invalid options.", http.StatusBadRequest)
+		return
+	}
+
+	if updated {
+		if err := pinBin(name, opts.Pinned); err != nil {
+			http.Error(w, "Could not pin bin.", http.StatusInternalServerError)
+			return
+		}
+	} else if opts.Pinned, err = isPinned(name); err != nil {
+		http.Error(w, "Could not get bin.", http.StatusInternalServerError)
+		return
+	}
+
+	expires, err := binExpires(name)
+	if err != nil {
+		http.Error(w, "Could not get bin.", http.StatusInternalServerError)
+		return
+	}
+
+	bin := map[string]interface{}{
+		"id":      name,
+		"pinned":  opts.Pinned,
+		"expires": expires,
+	}
+	if err := json.NewEncoder(w).Encode(bin); err != nil {
+		log.Println("Error marshalling bin:", err)
+		http.Error(w, "Could not get bin.", http.StatusInternalServerError)
+	}
+}
+
+// deleteHandler handles requests to /api/1/bins/{bin_id}/delete. It removes the bin along with
+// everything stored for it, and disconnects the sockets watching it. Bins owned by an account may
+// only be deleted by their owner.
+func deleteHandler(w http.ResponseWriter, r *http.Request, name string) {
+	owner, err := getOwner(name)
+	if err != nil {
+		http.Error(w, "Could not delete bin.", http.StatusInternalServerError)
+		return
+	}
+	if owner != "" && !authorize(w, r, name, ownerAccess) {
+		return
+	}
+
+	if err := deleteBin(name); err != nil {
+		http.Error(w, "Could not delete bin.", http.StatusInternalServerError)
+		return
+	}
+}
+
+// purgeHandler handles requests to /api/1/bins/{bin_id}/purge. It removes the requests stored in
+// the bin while keeping the bin, and responds with how many were removed, e.g.
+// `{ "removed": 12 }`. The request body may hold a JSON object of PurgeOptions to only remove
+// the requests received in a time range, e.g.:
+//
+// `{
+//    "from": 1400539133,
+//    "to": 1400542733
+// }`
+func purgeHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var po PurgeOptions
+	if _, err := decodeOptionalBody(r, &po); err != nil {
+		log.Println("Error unmarshalling purge options:", err)
+		http.Error(w, "Invalid purge options.", http.StatusBadRequest)
+		return
+	}
+	if err := po.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	removed, err := purgeRequests(name, po)
+	if err != nil {
+		http.Error(w, "Could not purge requests.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(map[string]int64{"removed": removed}); err != nil {
+		log.Println("Error marshalling purge result:", err)
+		http.Error(w, "Could not purge requests.", http.StatusInternalServerError)
+	}
+}
+
+// capHandler handles requests to /api/1/bins/{bin_id}/cap. With a JSON object of CapSettings in
+// the request body, it sets the most requests the bin keeps, e.g. `{ "maxRequests": 500 }`, or
+// goes back to the config's MaxBinRequests with 0. Once the bin is full, its oldest requests are
+// evicted. It responds with the cap in effect, 0 meaning every request is kept.
+func capHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var cs CapSettings
+	updated, err := decodeOptionalBody(r, &cs)
+	if err != nil {
+		log.Println("Error unmarshalling cap:", err)
+		http.Error(w, "Invalid cap.", http.StatusBadRequest)
+		return
+	}
+
+	if updated {
+		if err := cs.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if err := setBinSetting(name, maxRequestsSetting, cs); err != nil {
+			http.Error(w, "Could not save cap.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if cs.MaxRequests, err = getMaxRequests(name); err != nil {
+		http.Error(w, "Could not get cap.", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(cs); err != nil {
+		log.Println("Error marshalling cap:", err)
+		http.Error(w, "Could not get cap.", http.StatusInternalServerError)
+	}
+}
+
+// BinInfo describes a bin.
+type BinInfo struct {
+	ID      string `json:"id"`
+	Expires int64  `json:"expires"` // 0 if the bin doesn't expire
+	Pinned  bool   `json:"pinned"`
+	// number of requests stored in the bin
+	Requests int64 `json:"requests"`
+	// most requests the bin keeps, 0 if it keeps them all
+	MaxRequests int64 `json:"maxRequests"`
+	// number of requests evicted to keep the bin under its cap
+	Evicted int64 `json:"evicted"`
+}
+
+// infoHandler handles requests to /api/1/bins/{bin_id}/info. It responds with the bin's BinInfo.
+func infoHandler(w http.ResponseWriter, r *http.Request, name string) {
+	info := BinInfo{ID: name}
+	var err error
+	if info.Expires, err = binExpires(name); err != nil {
+		http.Error(w, "Could not get bin info.", http.StatusInternalServerError)
+		return
+	}
+	if info.Pinned, err = isPinned(name); err != nil {
+		http.Error(w, "Could not get bin info.", http.StatusInternalServerError)
+		return
+	}
+	if info.Requests, err = client.ZCard(name).Result(); err != nil {
+		log.Println("Failure to ZCARD", name, err)
+		http.Error(w, "Could not get bin info.", http.StatusInternalServerError)
+		return
+	}
+	// leave out the placeholder from when the bin was created
+	if info.Requests > 0 {
+		info.Requests--
+	}
+	if info.MaxRequests, err = getMaxRequests(name); err != nil {
+		http.Error(w, "Could not get bin info.", http.StatusInternalServerError)
+		return
+	}
+	if info.Evicted, err = getEvicted(name); err != nil {
+		http.Error(w, "Could not get bin info.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		log.Println("Error marshalling bin info:", err)
+		http.Error(w, "Could not get bin info.", http.StatusInternalServerError)
+	}
+}
+
+// BinSharing lists the account owning a bin and the accounts it is shared with.
+type BinSharing struct {
+	Owner   string   `json:"owner"`
+	Members []string `json:"members"`
+}
+
+// shareHandler handles requests to /api/1/bins/{bin_id}/share. With a JSON object of BinSharing
+// in the request body, it replaces the accounts the bin is shared with, e.g.:
+//
+// `{ "members": ["bob", "carol"] }`
+//
+// Those accounts can then use the bin with their API keys as if they had its write token. With an
+// empty body, it responds with the current sharing. Only the account owning the bin may share it.
+func shareHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var bs BinSharing
+	updated, err := decodeOptionalBody(r, &bs)
+	if err != nil {
+		log.Println("Error unmarshalling sharing:", err)
+		http.Error(w, "Invalid sharing.", http.StatusBadRequest)
+		return
+	}
+
+	if updated {
+		if bs.Members == nil {
+			bs.Members = make([]string, 0)
+		}
+		if err := shareBin(name, bs.Members); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	} else if bs.Members, err = getMembers(name); err != nil {
+		http.Error(w, "Could not get sharing.", http.StatusInternalServerError)
+		return
+	}
+
+	if bs.Owner, err = getOwner(name); err != nil {
+		http.Error(w, "Could not get sharing.", http.StatusInternalServerError)
+		return
+	}
+	if err := json.NewEncoder(w).Encode(bs); err != nil {
+		log.Println("Error marshalling sharing:", err)
+		http.Error(w, "Could not get sharing.", http.StatusInternalServerError)
+	}
+}
+
+// linksHandler handles requests to /api/1/bins/{bin_id}/links. The request body may hold a JSON
+// object of a LinkRequest, e.g.:
+//
+// `{
+//    "expiresIn": 86400,
+//    "from": 1400539133,
+//    "to": 1400542733
+// }`
+//
+// to create a ShareLink and respond with it, or `{ "revoke": "{token}" }` to revoke one. With an
+// empty body, it responds with the bin's current share links.
+func linksHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var lr LinkRequest
+	updated, err := decodeOptionalBody(r, &lr)
+	if err != nil {
+		log.Println("Error unmarshalling link request:", err)
+		http.Error(w, "Invalid link request.", http.StatusBadRequest)
+		return
+	}
+
+	var res interface{}
+	switch {
+	case !updated:
+		if res, err = getLinks(name); err != nil {
+			http.Error(w, "Could not get links.", http.StatusInternalServerError)
+			return
+		}
+	case lr.Revoke != "":
+		revoked, err := revokeLink(name, lr.Revoke)
+		if err != nil {
+			http.Error(w, "Could not revoke link.", http.StatusInternalServerError)
+			return
+		}
+		if !revoked {
+			http.NotFound(w, r)
+		}
+		return
+	default:
+		if err := lr.validate(); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if res, err = createLink(name, lr); err != nil {
+			http.Error(w, "Could not create link.", http.StatusInternalServerError)
+			return
+		}
+	}
+
+	if err := json.NewEncoder(w).Encode(res); err != nil {
+		log.Println("Error marshalling links:", err)
+		http.Error(w, "Could not get links.", http.StatusInternalServerError)
+	}
+}
+
+// replayHandler handles requests to /api/1/bins/{bin_id}/replay. The request body must be a JSON
+// object of a ReplayRequest naming a stored request of the bin and a URL to send it to, e.g.:
+//
+// `{
+//    "id": "1398551966-1398551966513000000",
+//    "url": "http://example.com/hook",
+//    "headers": { "Authorization": "Bearer abc123" }
+// }`
+//
+// The stored request is re-issued to the URL with its original method, headers and body, and a
+// ReplayResult holding the upstream response is written to the response.
+func replayHandler(w http.ResponseWriter, r *http.Request, name string) {
+	var rr ReplayRequest
+	found, err := decodeOptionalBody(r, &rr)
+	if err != nil {
+		log.Println("Error unmarshalling replay:", err)
+	}
+	if !found {
+		http.Error(w, "Invalid replay.", http.StatusBadRequest)
+		return
+	}
+	if err := rr.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	gr, _, err := findRequest(name, rr.ID)
+	if err != nil {
+		http.Error(w, "Could not get request.", http.StatusInternalServerError)
+		return
+	}
+	if gr == nil {
+		http.Error(w, "Request not found.", http.StatusNotFound)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(replay(name, gr, rr)); err != nil {
+		log.Println("Error marshalling replay:", err)
+		http.Error(w, "Could not replay request.", http.StatusInternalServerError)
+	}
+}
+
+// replaysHandler handles requests to /api/1/bins/{bin_id}/replays. It writes the replay log of the
+// bin, most recent first, to the response.
+func replaysHandler(w http.ResponseWriter, r *http.Request, name string) {
+	records, err := getReplays(name)
+	if err != nil {
+		http.Error(w, "Could not get replays.", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(records); err != nil {
+		log.Println("Error marshalling replays:", err)
+		http.Error(w, "Could not get replays.", http.StatusInternalServerError)
+	}
+}
+
+// decodeOptionalBody decodes the JSON request body into v if there is one. It returns true if a
+// body was decoded.
+func decodeOptionalBody(r *http.Request, v interface{}) (bool, error) {
+	if r.Body == nil {
+		return false, nil
+	}
+
+	err := json.NewDecoder(r.Body).Decode(v)
+	if err == io.EOF {
+		return false, nil
+	}
+	return err == nil, err
+}
+
+// wsHandler handles requests to /api/1/ws/{bin_id}. It requires a bin_id in the request path
+// and it subscribes to listen for changes to the bin_id in redis. It creates a socket with
+// a UUID and adds that socket to the socketMap. It then sends any updates to the bin_id in
+// redis to the socket as they come in.
+//
+// A `filter` query parameter holding a JSON StreamFilter limits which requests are sent to the
+// socket. It can be changed later by sending a SocketMessage over the socket.
+//
+// A client reconnecting after its socket dropped can pass either a `since` Unix timestamp or the
+// `lastId` of the last request it got as query parameters. The requests it missed are then read
+// from the bin's history and sent before any new ones.
+func wsHandler(w http.ResponseWriter, r *http.Request) {
+	debugLog("create -", r.URL)
+	path := strings.Split(r.URL.Path, "/")
+	binName := path[len(path)-1]
+
+	query := r.URL.Query()
+	resume := query.Get("since") != "" || query.Get("lastId") != ""
+	var since int64
+	if v := query.Get("since"); v != "" {
+		var err error
+		if since, err = strconv.ParseInt(v, 10, 64); err != nil {
+			http.Error(w, "since must be a Unix timestamp.", http.StatusBadRequest)
+			return
+		}
+	}
+	lastID := query.Get("lastId")
+	if _, _, ok := parseRequestID(lastID); lastID != "" && !ok {
+		http.Error(w, "lastId must be the id of a request.", http.StatusBadRequest)
+		return
+	}
+	var filter *StreamFilter
+	if v := query.Get("filter"); v != "" {
+		var err error
+		if filter, err = parseStreamFilter([]byte(v)); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if !authorize(w, r, binName, viewAccess) {
+		return
+	}
+
+	link, err := getLink(binName, requestToken(r))
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// start pub subbing
+	if err := pubsub.Subscribe(binName); err != nil {
+		log.Println("Failure to SUBSCRIBE to", binName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Println("Failure to generate new socket UUID", binName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	uuid := id.String()
+
+	control := newSocketControl(binName, uuid)
+	s, err := NewSocket(binName+"~br~"+uuid, w, r, control.onRead, func(socketName string) {
+		// the socketname is a composite of the bin name, and the socket UUID
+		ids := strings.Split(socketName, "~br~")
+		bn := ids[0]
+		suuid := ids[1]
+		if err := socketMap.Delete(bn, suuid); err != nil {
+			log.Println(err)
+		}
+	})
+
+	if err != nil {
+		// if there is an error, NewSocket will have already written a response via http.Error()
+		// so only write a log
+		log.Println("Error opening websocket:", err)
+		return
+	}
+
+	if !resume {
+		// keep track of the outbound channel for pubsubbery
+		socketMap.Add(binName, uuid, withLink(s, link), filter)
+		control.start(s)
+		return
+	}
+
+	// the socket is added before the backlog is read so that nothing published in between is
+	// missed, anything published is held back until the backlog has been sent
+	rs := newResumeSocket(s)
+	socketMap.Add(binName, uuid, withLink(rs, link), filter)
+
+	backlog, err := getHistorySince(binName, since, lastID)
+	if err != nil {
+		log.Println("Failure to get missed requests for", binName, err)
+	}
+	if filter != nil {
+		matched := make([]*GeobinRequest, 0, len(backlog))
+		for _, gr := range backlog {
+			if filter.matches(gr) {
+				matched = append(matched, gr)
+			}
+		}
+		backlog = matched
+	}
+	if link != nil {
+		backlog = link.filterWindow(backlog)
+	}
+	rs.catchUp(backlog)
+	control.start(rs)
+}
+
+// wsMuxHandler handles requests to /api/1/ws. It opens a websocket that isn't subscribed to any
+// bins until it is sent "subscribe" SocketMessages, e.g.:
+//
+// `{ "type": "subscribe", "bin": "PF4C5zm67N", "filter": { "hasGeo": true } }`
+//
+// Updates to each bin it is subscribed to are wrapped in an envelope naming the bin.
+func wsMuxHandler(w http.ResponseWriter, r *http.Request) {
+	debugLog("ws -", r.URL)
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Println("Failure to generate new socket UUID", err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	uuid := id.String()
+
+	control := newSocketControl("", uuid)
+	control.token = requestToken(r)
+	s, err := NewSocket(uuid, w, r, control.onRead, func(socketName string) {
+		if err := socketMap.DeleteAll(socketName); err != nil {
+			log.Println(err)
+		}
+	})
+	if err != nil {
+		// if there is an error, NewSocket will have already written a response via http.Error()
+		// so only write a log
+		log.Println("Error opening websocket:", err)
+		return
+	}
+
+	control.start(s)
+}
+
+// sseHandler handles requests to /api/1/sse/{bin_id}. It streams updates to the bin_id in redis to
+// the client as Server-Sent Events, the same way as wsHandler does over a websocket. Each event has
+// the ID of its request, and if the client sends a Last-Event-ID header (or a lastEventId query
+// parameter), any requests stored after that one are sent first.
+func sseHandler(w http.ResponseWriter, r *http.Request) {
+	debugLog("sse -", r.URL)
+	path := strings.Split(r.URL.Path, "/")
+	binName := path[len(path)-1]
+
+	exists, err := nameExists(binName)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !authorize(w, r, binName, viewAccess) {
+		return
+	}
+
+	link, err := getLink(binName, requestToken(r))
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// start pub subbing
+	if err := pubsub.Subscribe(binName); err != nil {
+		log.Println("Failure to SUBSCRIBE to", binName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Println("Failure to generate new socket UUID", binName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	uuid := id.String()
+
+	s, err := NewSSESocket(binName+"~br~"+uuid, w, r, func(socketName string) {
+		// the socketname is a composite of the bin name, and the socket UUID
+		ids := strings.Split(socketName, "~br~")
+		if err := socketMap.Delete(ids[0], ids[1]); err != nil {
+			log.Println(err)
+		}
+	})
+	if err != nil {
+		log.Println("Error opening event stream:", err)
+		return
+	}
+
+	// the socket is added before the backlog is read so that nothing published in between is missed
+	socketMap.Add(binName, uuid, withLink(s, link), nil)
+
+	lastID := r.Header.Get("Last-Event-ID")
+	if lastID == "" {
+		lastID = r.URL.Query().Get("lastEventId")
+	}
+
+	var backlog []*GeobinRequest
+	if _, _, ok := parseRequestID(lastID); ok {
+		if backlog, err = getHistorySince(binName, 0, lastID); err != nil {
+			log.Println("Failure to get missed requests for", binName, err)
+		}
+	}
+	if link != nil {
+		backlog = link.filterWindow(backlog)
+	}
+
+	s.Serve(backlog)
+}
+
+// pollHandler handles requests to /api/1/poll/{bin_id}. It waits for requests to arrive in the bin
+// after the cursor given in the request body, then writes them to the response as a PollResult.
+// The request body may hold a JSON object of PollOptions, e.g.:
+//
+// `{
+//    "cursor": "1400539133-1400539133512304000",
+//    "timeout": 30
+// }`
+//
+// Like wsHandler, it is woken up by updates to the bin_id in redis.
+func pollHandler(w http.ResponseWriter, r *http.Request) {
+	debugLog("poll -", r.URL)
+	path := strings.Split(r.URL.Path, "/")
+	binName := path[len(path)-1]
+
+	var po PollOptions
+	if _, err := decodeOptionalBody(r, &po); err != nil {
+		log.Println("Error unmarshalling poll options:", err)
+		http.Error(w, "Invalid poll options.", http.StatusBadRequest)
+		return
+	}
+	if err := po.validate(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	exists, err := nameExists(binName)
+	if err != nil {
+		http.Error(w, "Internal error", http.StatusInternalServerError)
+		return
+	}
+
+	if !exists {
+		http.NotFound(w, r)
+		return
+	}
+
+	if !authorize(w, r, binName, viewAccess) {
+		return
+	}
+
+	link, err := getLink(binName, requestToken(r))
+	if err != nil {
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	// start pub subbing
+	if err := pubsub.Subscribe(binName); err != nil {
+		log.Println("Failure to SUBSCRIBE to", binName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+
+	id, err := uuid.NewV4()
+	if err != nil {
+		log.Println("Failure to generate new socket UUID", binName, err)
+		http.Error(w, "Internal server error", http.StatusInternalServerError)
+		return
+	}
+	uuid := id.String()
+
+	// the socket is added before the bin is checked for requests so that nothing published in
+	// between is missed
+	ps := newPollSocket(binName + "~br~" + uuid)
+	socketMap.Add(binName, uuid, ps, nil)
+	defer func() {
+		if err := socketMap.Delete(binName, uuid); err != nil {
+			log.Println(err)
+		}
+	}()
+
+	result, err := poll(binName, po, ps, r.Context().Done())
+	if err != nil {
+		http.Error(w, "Could not get requests.", http.StatusInternalServerError)
+		return
+	}
+	if link != nil {
+		// the cursor still moves past what the link hides
+		result.Requests = link.filterWindow(result.Requests)
+	}
+
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		log.Println("Error marshalling poll result:", err)
+		http.Error(w, "Could not get requests.", http.StatusInternalServerError)
+	}
 }
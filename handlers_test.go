@@ -5,10 +5,12 @@ import (
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"strings"
 	"testing"
+	"time"
 
 	"github.com/bmizerany/assert"
 )
@@ -38,6 +40,62 @@ func TestBinHandler404(t *testing.T) {
 	assertResponseNotFound(w, t)
 }
 
+func TestSSEHandler404(t *testing.T) {
+	req, err := http.NewRequest("GET", "http://testing.geobin.io/api/1/sse/nonexistant_bin", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	sseHandler(w, req)
+
+	assertResponseNotFound(w, t)
+}
+
+func TestGetHistorySince(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	for _, payload := range []string{`{"lat": 1, "lng": 1}`, `{"lat": 2, "lng": 2}`, `{"lat": 3, "lng": 3}`} {
+		if _, err := postToBin(binId, payload); err != nil {
+			t.Error(err)
+		}
+	}
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 3, len(history))
+
+	// history is newest first, the backlog oldest first
+	missed, err := getHistorySince(binId, 0, history[2].ID)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 2, len(missed))
+	assert.Equal(t, history[1].ID, missed[0].ID)
+	assert.Equal(t, history[0].ID, missed[1].ID)
+
+	all, err := getHistorySince(binId, history[2].Timestamp, "")
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 3, len(all))
+}
+
+func TestWSHandlerInvalidResume(t *testing.T) {
+	for _, query := range []string{"since=yesterday", "lastId=abc"} {
+		req, err := http.NewRequest("GET", "http://testing.geobin.io/api/1/ws/bin?"+query, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		w := httptest.NewRecorder()
+		wsHandler(w, req)
+		assertResponseCode(w, http.StatusBadRequest, t)
+	}
+}
+
 func TestBinHandlerEmptyBody(t *testing.T) {
 	binId, err := createBin()
 	if err != nil {
@@ -163,6 +221,36 @@ func TestCountsWithInvalidBinId(t *testing.T) {
 	verifyCounts(bins, expected, t)
 }
 
+func TestCountsWithViewers(t *testing.T) {
+	bins, _ := createBins([]int{2, 0}, t)
+
+	pm := newPresenceMap(NewSocketMap(nil))
+	pm.Add(bins[0], "socket_uuid1", &MockSocket{name: "mock_socket1"}, nil)
+	pm.Add(bins[0], "socket_uuid2", &MockSocket{name: "mock_socket2"}, nil)
+	defer pm.DeleteAll("socket_uuid1")
+	defer pm.DeleteAll("socket_uuid2")
+
+	binJson, err := json.Marshal(append(bins, "invalid"))
+	if err != nil {
+		t.Error(err)
+	}
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/counts?viewers=true", strings.NewReader(string(binJson)))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	countsHandler(w, req)
+	assertResponseOK(w, t)
+
+	var got map[string]interface{}
+	json.Unmarshal(w.Body.Bytes(), &got)
+	assert.Equal(t, map[string]interface{}{
+		bins[0]:   map[string]interface{}{"requests": float64(2), "viewers": float64(2)},
+		bins[1]:   map[string]interface{}{"requests": float64(0), "viewers": float64(0)},
+		"invalid": nil,
+	}, got)
+}
+
 func createBins(counts []int, t *testing.T) (binIds []string, expected map[string]interface{}) {
 	binIds = make([]string, len(counts))
 	expected = make(map[string]interface{})
@@ -213,6 +301,877 @@ func verifyCounts(bins []string, expected map[string]interface{}, t *testing.T)
 	assert.Equal(t, expected, got)
 }
 
+func TestBinsHandler404(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	for _, path := range []string{"neverland/tracks", binId + "/unknown", binId} {
+		req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+path, nil)
+		if err != nil {
+			t.Error(err)
+		}
+		w := httptest.NewRecorder()
+		binsHandler(w, req)
+
+		assertResponseNotFound(w, t)
+	}
+}
+
+func TestTracksHandler(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	for _, payload := range []string{
+		`{"id": "a", "lat": 10, "lng": -10}`,
+		`{"id": "b", "lat": 20, "lng": -20}`,
+		`{"id": "a", "lat": 10.1, "lng": -10.1}`,
+	} {
+		if _, err := postToBin(binId, payload); err != nil {
+			t.Error(err)
+		}
+	}
+
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/tracks", strings.NewReader(`{"deviceKey": "id"}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	binsHandler(w, req)
+
+	assertResponseOK(w, t)
+
+	var fc map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &fc); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, "FeatureCollection", fc["type"])
+
+	geoms := make(map[string]string)
+	for _, f := range fc["features"].([]interface{}) {
+		feature := f.(map[string]interface{})
+		device := feature["properties"].(map[string]interface{})["device"].(string)
+		geoms[device] = feature["geometry"].(map[string]interface{})["type"].(string)
+	}
+	assert.Equal(t, map[string]string{"a": "LineString", "b": "Point"}, geoms)
+}
+
+func TestTracksHandlerInvalidOptions(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/tracks", strings.NewReader(`deal with it`))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	binsHandler(w, req)
+
+	assertResponseCode(w, http.StatusBadRequest, t)
+}
+
+func TestQueryHandler(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	for _, payload := range []string{
+		`{"lat": 10, "lng": -10}`,
+		`{"lat": 45.5, "lng": -122.5}`,
+	} {
+		if _, err := postToBin(binId, payload); err != nil {
+			t.Error(err)
+		}
+	}
+
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/query", strings.NewReader(`{"bbox": [-11, 9, -9, 11]}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	binsHandler(w, req)
+
+	assertResponseOK(w, t)
+
+	var results []map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &results); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(results))
+	assert.Equal(t, `{"lat": 10, "lng": -10}`, results[0]["request"].(map[string]interface{})["body"])
+	assert.Equal(t, []interface{}{[]interface{}{}}, results[0]["paths"])
+
+	// invalid queries are rejected
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/query", strings.NewReader(`{"bbox": [1, 2]}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binsHandler(w, req)
+
+	assertResponseCode(w, http.StatusBadRequest, t)
+}
+
+func TestFencesHandler(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	fences := `{"fences": [{"name": "home", "point": [-10, 10], "radius": 100}]}`
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/fences", strings.NewReader(fences))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseOK(w, t)
+
+	// an empty body returns the current fences
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/fences", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseOK(w, t)
+
+	var got FenceSettings
+	if err := json.Unmarshal(w.Body.Bytes(), &got); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(got.Fences))
+	assert.Equal(t, "home", got.Fences[0].Name)
+
+	// entering the fence adds an event to the history
+	if _, err := postToBin(binId, `{"lat": 10, "lng": -10}`); err != nil {
+		t.Error(err)
+	}
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 2, len(history))
+
+	var event *FenceEvent
+	for _, gr := range history {
+		if gr.Event != nil {
+			event = gr.Event
+		}
+	}
+	assert.Equal(t, &FenceEvent{Type: "enter", Fence: "home", Device: ""}, event)
+
+	// invalid fences are rejected
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/fences", strings.NewReader(`{"fences": [{"name": "nowhere"}]}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseCode(w, http.StatusBadRequest, t)
+}
+
+func TestMocksHandler(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	mocks := `{"rules": [{"match": {"path": "/orders/*"}, "status": 201, "body": "created"}]}`
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/mocks", strings.NewReader(mocks))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseOK(w, t)
+
+	// matching requests get the mock response
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/"+binId+"/orders/42", strings.NewReader(`{"lat": 10, "lng": -10}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binHandler(w, req)
+	assertResponseCode(w, http.StatusCreated, t)
+	assert.Equal(t, "created", w.Body.String())
+
+	// others get an empty 200
+	w, err = postToBin(binId, `{"lat": 10, "lng": -10}`)
+	if err != nil {
+		t.Error(err)
+	}
+	assertResponseOK(w, t)
+	assert.Equal(t, "", w.Body.String())
+
+	// both requests are stored
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 2, len(history))
+
+	// invalid mocks are rejected
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/mocks", strings.NewReader(`{"rules": [{"status": 1000}]}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseCode(w, http.StatusBadRequest, t)
+}
+
+func TestReplayHandler(t *testing.T) {
+	defer allowPrivateTargets()()
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		w.Write(body)
+	}))
+	defer ts.Close()
+
+	payload := `{"lat": 10, "lng": -10}`
+	if _, err := postToBin(binId, payload); err != nil {
+		t.Error(err)
+	}
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(history))
+
+	replay := `{"id": "` + history[0].ID + `", "url": "` + ts.URL + `"}`
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/replay", strings.NewReader(replay))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseOK(w, t)
+
+	var result ReplayResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, http.StatusOK, result.Replay.Status)
+	assert.Equal(t, payload, result.Response.Body)
+
+	// the replay is logged against the bin
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/replays", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseOK(w, t)
+
+	var records []ReplayRecord
+	if err := json.Unmarshal(w.Body.Bytes(), &records); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(records))
+	assert.Equal(t, history[0].ID, records[0].RequestID)
+
+	// unknown requests are not found
+	replay = `{"id": "1-1", "url": "` + ts.URL + `"}`
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/replay", strings.NewReader(replay))
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseNotFound(w, t)
+
+	// replays need a body
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/replay", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	binsHandler(w, req)
+	assertResponseCode(w, http.StatusBadRequest, t)
+}
+
+func TestPollHandler(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	if _, err := postToBin(binId, `{"lat": 10, "lng": -10}`); err != nil {
+		t.Error(err)
+	}
+
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/poll/"+binId, strings.NewReader(`{"cursor": "1-1", "timeout": 1}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	pollHandler(w, req)
+	assertResponseOK(w, t)
+
+	var result PollResult
+	if err := json.Unmarshal(w.Body.Bytes(), &result); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(result.Requests))
+	assert.Equal(t, result.Requests[0].ID, result.Cursor)
+
+	// invalid options are rejected
+	req, err = http.NewRequest("POST", "http://testing.geobin.io/api/1/poll/"+binId, strings.NewReader(`{"timeout": 600}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	pollHandler(w, req)
+	assertResponseCode(w, http.StatusBadRequest, t)
+}
+
+func TestPrivateBin(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/create", strings.NewReader(`{"private": true}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	createHandler(w, req)
+	assertResponseOK(w, t)
+
+	var bin map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &bin); err != nil {
+		t.Error(err)
+	}
+	binId := bin["id"].(string)
+	readToken := bin["readToken"].(string)
+	writeToken := bin["writeToken"].(string)
+
+	send := func(url, token, payload string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, strings.NewReader(payload))
+		if err != nil {
+			t.Error(err)
+		}
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	// sending requests needs the write token
+	assertResponseCode(send("http://testing.geobin.io/"+binId, "", `{}`, binHandler), http.StatusUnauthorized, t)
+	assertResponseCode(send("http://testing.geobin.io/"+binId, readToken, `{}`, binHandler), http.StatusForbidden, t)
+	assertResponseOK(send("http://testing.geobin.io/"+binId, writeToken, `{}`, binHandler), t)
+
+	// reading the history needs either token
+	assertResponseCode(send("http://testing.geobin.io/api/1/history/"+binId, "", "", historyHandler), http.StatusUnauthorized, t)
+	assertResponseOK(send("http://testing.geobin.io/api/1/history/"+binId, readToken, "", historyHandler), t)
+	assertResponseOK(send("http://testing.geobin.io/api/1/history/"+binId, writeToken, "", historyHandler), t)
+
+	// changing settings needs the write token, reading derived data only the read token
+	assertResponseCode(send("http://testing.geobin.io/api/1/bins/"+binId+"/fences", readToken, "", binsHandler), http.StatusForbidden, t)
+	assertResponseOK(send("http://testing.geobin.io/api/1/bins/"+binId+"/fences", writeToken, "", binsHandler), t)
+	assertResponseOK(send("http://testing.geobin.io/api/1/bins/"+binId+"/replays", readToken, "", binsHandler), t)
+
+	// counts of private bins are hidden without a token
+	w = send("http://testing.geobin.io/api/1/counts", "", `["`+binId+`"]`, countsHandler)
+	assertResponseOK(w, t)
+	assert.Equal(t, `{"`+binId+`":null}`, strings.TrimSpace(w.Body.String()))
+	w = send("http://testing.geobin.io/api/1/counts", readToken, `["`+binId+`"]`, countsHandler)
+	assertResponseOK(w, t)
+	assert.Equal(t, `{"`+binId+`":1}`, strings.TrimSpace(w.Body.String()))
+}
+
+func TestSignatureHandler(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	setSignature := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/signature", strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+		}
+		w := httptest.NewRecorder()
+		binsHandler(w, req)
+		return w
+	}
+
+	assertResponseCode(setSignature(`{"scheme": "md5", "secret": "s3cr3t"}`), http.StatusBadRequest, t)
+	assertResponseOK(setSignature(`{"scheme": "github", "secret": "s3cr3t", "reject": true}`), t)
+
+	w := setSignature("")
+	assertResponseOK(w, t)
+	// the secret can't be read back
+	assert.Equal(t, `{"scheme":"github","secret":"********","reject":true}`, strings.TrimSpace(w.Body.String()))
+
+	// unsigned requests are rejected, but still recorded
+	w, err = postToBin(binId, `{"lat": 10, "lng": -10}`)
+	if err != nil {
+		t.Error(err)
+	}
+	assertResponseCode(w, http.StatusUnauthorized, t)
+
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(history))
+	assert.Equal(t, &SignatureResult{Valid: false, Reason: "missing X-Hub-Signature-256 header"}, history[0].Signature)
+
+	// settings read back and posted again keep the stored secret
+	assertResponseOK(setSignature(`{"scheme":"github","secret":"********","reject":false}`), t)
+	ss, err := getSignatureSettings(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, "s3cr3t", ss.Secret)
+	assert.Equal(t, false, ss.Reject)
+
+	// removing the settings stops checking signatures
+	assertResponseOK(setSignature("null"), t)
+
+	// with nothing stored, the masked secret isn't a secret
+	assertResponseCode(setSignature(`{"scheme":"github","secret":"********"}`), http.StatusBadRequest, t)
+	w, err = postToBin(binId, `{"lat": 10, "lng": -10}`)
+	if err != nil {
+		t.Error(err)
+	}
+	assertResponseOK(w, t)
+}
+
+func TestRedactionHandler(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	setRedaction := func(body string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/redaction", strings.NewReader(body))
+		if err != nil {
+			t.Error(err)
+		}
+		w := httptest.NewRecorder()
+		binsHandler(w, req)
+		return w
+	}
+
+	assertResponseCode(setRedaction(`{"paths": ["user.email"]}`), http.StatusBadRequest, t)
+	assertResponseOK(setRedaction(`{"paths": ["$.user.email"]}`), t)
+
+	w := setRedaction("")
+	assertResponseOK(w, t)
+	assert.Equal(t, `{"paths":["$.user.email"]}`, strings.TrimSpace(w.Body.String()))
+
+	if _, err := postToBin(binId, `{"lat": 10, "lng": -10, "user": {"email": "a@example.com"}}`); err != nil {
+		t.Error(err)
+	}
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(history))
+	assert.T(t, !strings.Contains(history[0].Body, "a@example.com"))
+	assert.Equal(t, []string{"body.user.email"}, history[0].Redacted)
+	assert.Equal(t, 1, len(history[0].Geo))
+
+	// removing the rules stops redacting
+	assertResponseOK(setRedaction("null"), t)
+	if _, err := postToBin(binId, `{"user": {"email": "a@example.com"}}`); err != nil {
+		t.Error(err)
+	}
+	history, err = getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 2, len(history))
+	assert.T(t, strings.Contains(history[1].Body, "a@example.com"))
+}
+
+func TestAccountHandlers(t *testing.T) {
+	username := fmt.Sprint("handler-", time.Now().UnixNano())
+	post := func(url, token, payload string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, strings.NewReader(payload))
+		if err != nil {
+			t.Error(err)
+		}
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	w := post("http://testing.geobin.io/api/1/accounts", "", `{"username": "`+username+`", "password": "hunter2"}`, accountsHandler)
+	assertResponseOK(w, t)
+	var a Account
+	if err := json.Unmarshal(w.Body.Bytes(), &a); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, username, a.Username)
+	assert.Equal(t, "", a.Password)
+
+	assertResponseCode(post("http://testing.geobin.io/api/1/accounts", "", `{"username": "`+username+`"}`, accountsHandler), http.StatusConflict, t)
+	assertResponseCode(post("http://testing.geobin.io/api/1/login", "", `{"username": "`+username+`", "password": "nope"}`, loginHandler), http.StatusUnauthorized, t)
+	w = post("http://testing.geobin.io/api/1/login", "", `{"username": "`+username+`", "password": "hunter2"}`, loginHandler)
+	assertResponseOK(w, t)
+	assertBodyContainsKey(w.Body, "apiKey", t)
+
+	// bins created with an API key are owned by the account
+	assertResponseCode(post("http://testing.geobin.io/api/1/create", "not-a-key", "", createHandler), http.StatusUnauthorized, t)
+	w = post("http://testing.geobin.io/api/1/create", a.APIKey, "", createHandler)
+	assertResponseOK(w, t)
+	var bin map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &bin); err != nil {
+		t.Error(err)
+	}
+	binId := bin["id"].(string)
+	assert.Equal(t, username, bin["owner"])
+
+	assertResponseCode(post("http://testing.geobin.io/api/1/account/bins", "", "", accountBinsHandler), http.StatusUnauthorized, t)
+	w = post("http://testing.geobin.io/api/1/account/bins", a.APIKey, "", accountBinsHandler)
+	assertResponseOK(w, t)
+	var bins []AccountBin
+	if err := json.Unmarshal(w.Body.Bytes(), &bins); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(bins))
+	assert.Equal(t, binId, bins[0].ID)
+	assert.Equal(t, ownerRole, bins[0].Role)
+
+	// only the owner can delete the bin
+	assertResponseCode(post("http://testing.geobin.io/api/1/bins/"+binId+"/delete", "", "", binsHandler), http.StatusUnauthorized, t)
+	assertResponseOK(post("http://testing.geobin.io/api/1/bins/"+binId+"/delete", a.APIKey, "", binsHandler), t)
+	assertResponseNotFound(post("http://testing.geobin.io/api/1/history/"+binId, "", "", historyHandler), t)
+}
+
+func TestLinksHandler(t *testing.T) {
+	req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/create", strings.NewReader(`{"private": true}`))
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	createHandler(w, req)
+	var bin map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &bin); err != nil {
+		t.Error(err)
+	}
+	binId := bin["id"].(string)
+	writeToken := bin["writeToken"].(string)
+
+	send := func(url, token, payload string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, strings.NewReader(payload))
+		if err != nil {
+			t.Error(err)
+		}
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+
+	assertResponseOK(send("http://testing.geobin.io/"+binId, writeToken, `{"lat": 10, "lng": -10}`, binHandler), t)
+
+	w = send("http://testing.geobin.io/api/1/bins/"+binId+"/links", writeToken, `{"expiresIn": 3600}`, binsHandler)
+	assertResponseOK(w, t)
+	var link ShareLink
+	if err := json.Unmarshal(w.Body.Bytes(), &link); err != nil {
+		t.Error(err)
+	}
+
+	// the link can view the bin, but not send to it or create more links
+	w = send("http://testing.geobin.io/api/1/history/"+binId, link.Token, "", historyHandler)
+	assertResponseOK(w, t)
+	var history []*GeobinRequest
+	if err := json.Unmarshal(w.Body.Bytes(), &history); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 1, len(history))
+	assertResponseCode(send("http://testing.geobin.io/"+binId, link.Token, `{}`, binHandler), http.StatusForbidden, t)
+	assertResponseCode(send("http://testing.geobin.io/api/1/bins/"+binId+"/links", link.Token, "", binsHandler), http.StatusForbidden, t)
+
+	// a link for a window the request isn't in doesn't show it
+	w = send("http://testing.geobin.io/api/1/bins/"+binId+"/links", writeToken, `{"from": 1, "to": 2}`, binsHandler)
+	assertResponseOK(w, t)
+	var windowed ShareLink
+	if err := json.Unmarshal(w.Body.Bytes(), &windowed); err != nil {
+		t.Error(err)
+	}
+	w = send("http://testing.geobin.io/api/1/history/"+binId, windowed.Token, "", historyHandler)
+	assertResponseOK(w, t)
+	assert.Equal(t, "[]", strings.TrimSpace(w.Body.String()))
+
+	// nor through the bin's other data, which links can't read
+	assertResponseCode(send("http://testing.geobin.io/api/1/bins/"+binId+"/query", windowed.Token, `{"bbox": [-180, -90, 180, 90]}`, binsHandler), http.StatusForbidden, t)
+	assertResponseCode(send("http://testing.geobin.io/api/1/bins/"+binId+"/tracks", windowed.Token, "", binsHandler), http.StatusForbidden, t)
+	assertResponseCode(send("http://testing.geobin.io/api/1/bins/"+binId+"/replays", windowed.Token, "", binsHandler), http.StatusForbidden, t)
+	assertResponseCode(send("http://testing.geobin.io/api/1/bins/"+binId+"/info", windowed.Token, "", binsHandler), http.StatusForbidden, t)
+	assertResponseOK(send("http://testing.geobin.io/api/1/bins/"+binId+"/query", writeToken, `{"bbox": [-180, -90, 180, 90]}`, binsHandler), t)
+
+	assertResponseCode(send("http://testing.geobin.io/api/1/bins/"+binId+"/links", writeToken, `{"from": 2, "to": 1}`, binsHandler), http.StatusBadRequest, t)
+
+	// revoked links stop working
+	assertResponseOK(send("http://testing.geobin.io/api/1/bins/"+binId+"/links", writeToken, `{"revoke": "`+link.Token+`"}`, binsHandler), t)
+	assertResponseCode(send("http://testing.geobin.io/api/1/history/"+binId, link.Token, "", historyHandler), http.StatusForbidden, t)
+	assertResponseNotFound(send("http://testing.geobin.io/api/1/bins/"+binId+"/links", writeToken, `{"revoke": "`+link.Token+`"}`, binsHandler), t)
+}
+
+func TestBinLifetime(t *testing.T) {
+	post := func(url, token, payload string, h http.HandlerFunc) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", url, strings.NewReader(payload))
+		if err != nil {
+			t.Error(err)
+		}
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		h(w, req)
+		return w
+	}
+	expires := func(w *httptest.ResponseRecorder) int64 {
+		var bin struct {
+			Expires int64 `json:"expires"`
+		}
+		if err := json.Unmarshal(w.Body.Bytes(), &bin); err != nil {
+			t.Error(err)
+		}
+		return bin.Expires
+	}
+
+	assertResponseCode(post("http://testing.geobin.io/api/1/create", "", `{"ttl": 1}`, createHandler), http.StatusBadRequest, t)
+	w := post("http://testing.geobin.io/api/1/create", "", `{"ttl": 7200}`, createHandler)
+	assertResponseOK(w, t)
+	assert.T(t, expires(w)-time.Now().Unix() <= 7200)
+	var bin map[string]interface{}
+	if err := json.Unmarshal(w.Body.Bytes(), &bin); err != nil {
+		t.Error(err)
+	}
+	binId := bin["id"].(string)
+
+	w = post("http://testing.geobin.io/api/1/bins/"+binId+"/extend", "", `{"ttl": 86400}`, binsHandler)
+	assertResponseOK(w, t)
+	assert.T(t, expires(w)-time.Now().Unix() > 7200)
+	ttl, err := client.TTL(binId).Result()
+	assert.Equal(t, nil, err)
+	assert.T(t, ttl > 2*time.Hour)
+
+	// extending a bin doesn't count against the cap on bins created
+	maxBins := config.MaxBinsPerHour
+	config.MaxBinsPerHour = 1
+	for i := 0; i < 2; i++ {
+		countBinCreation(time.Now())
+	}
+	assertResponseCode(post("http://testing.geobin.io/api/1/create", "", "", createHandler), http.StatusTooManyRequests, t)
+	assertResponseOK(post("http://testing.geobin.io/api/1/bins/"+binId+"/extend", "", "", binsHandler), t)
+	config.MaxBinsPerHour = maxBins
+
+	// only the admin can pin bins
+	assertResponseCode(post("http://testing.geobin.io/api/1/bins/"+binId+"/pin", "", `{"pinned": true}`, binsHandler), http.StatusUnauthorized, t)
+	config.AdminKey = "test-admin-key"
+	defer func() { config.AdminKey = "" }()
+	w = post("http://testing.geobin.io/api/1/bins/"+binId+"/pin", config.AdminKey, `{"pinned": true}`, binsHandler)
+	assertResponseOK(w, t)
+	assert.Equal(t, int64(0), expires(w))
+	assertResponseCode(post("http://testing.geobin.io/api/1/bins/"+binId+"/extend", "", "", binsHandler), http.StatusConflict, t)
+
+	w = post("http://testing.geobin.io/api/1/bins/"+binId+"/pin", config.AdminKey, `{"pinned": false}`, binsHandler)
+	assertResponseOK(w, t)
+	assert.NotEqual(t, int64(0), expires(w))
+}
+
+func TestPurgeAndDeleteHandlers(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+	if _, err := postToBin(binId, `{"lat": 10, "lng": -10}`); err != nil {
+		t.Error(err)
+	}
+
+	post := func(action, payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/"+action, strings.NewReader(payload))
+		if err != nil {
+			t.Error(err)
+		}
+		w := httptest.NewRecorder()
+		binsHandler(w, req)
+		return w
+	}
+
+	assertResponseCode(post("purge", `{"from": 2, "to": 1}`), http.StatusBadRequest, t)
+	w := post("purge", "")
+	assertResponseOK(w, t)
+	assert.Equal(t, `{"removed":1}`, strings.TrimSpace(w.Body.String()))
+
+	assertResponseOK(post("delete", ""), t)
+	exists, err := nameExists(binId)
+	assert.Equal(t, nil, err)
+	assert.T(t, !exists)
+	assertResponseNotFound(post("purge", ""), t)
+}
+
+func TestCapAndInfoHandlers(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	post := func(action, payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bins/"+binId+"/"+action, strings.NewReader(payload))
+		if err != nil {
+			t.Error(err)
+		}
+		w := httptest.NewRecorder()
+		binsHandler(w, req)
+		return w
+	}
+
+	assertResponseCode(post("cap", `{"maxRequests": -1}`), http.StatusBadRequest, t)
+	w := post("cap", `{"maxRequests": 1}`)
+	assertResponseOK(w, t)
+	assert.Equal(t, `{"maxRequests":1}`, strings.TrimSpace(w.Body.String()))
+
+	for i := 0; i < 2; i++ {
+		if _, err := postToBin(binId, `{"lat": 10, "lng": -10}`); err != nil {
+			t.Error(err)
+		}
+	}
+
+	w = post("info", "")
+	assertResponseOK(w, t)
+	var info BinInfo
+	if err := json.Unmarshal(w.Body.Bytes(), &info); err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, binId, info.ID)
+	assert.Equal(t, int64(1), info.Requests)
+	assert.Equal(t, int64(1), info.MaxRequests)
+	assert.Equal(t, int64(1), info.Evicted)
+	assert.T(t, info.Expires > 0)
+}
+
+func TestBansHandler(t *testing.T) {
+	key := config.AdminKey
+	defer func() { config.AdminKey = key }()
+	config.AdminKey = "admin-s3cr3t"
+
+	post := func(token, payload string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/bans", strings.NewReader(payload))
+		if err != nil {
+			t.Error(err)
+		}
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		bansHandler(w, req)
+		return w
+	}
+
+	assertResponseCode(post("", ""), http.StatusUnauthorized, t)
+	assertResponseCode(post("wrong", ""), http.StatusForbidden, t)
+
+	ip := fmt.Sprint("handler-", time.Now().UnixNano())
+	if err := banIP(ip, "testing", time.Minute, time.Now()); err != nil {
+		t.Error(err)
+	}
+
+	hasBan := func(w *httptest.ResponseRecorder) bool {
+		var bans []Ban
+		if err := json.Unmarshal(w.Body.Bytes(), &bans); err != nil {
+			t.Error(err)
+		}
+		for _, b := range bans {
+			if b.IP == ip {
+				return true
+			}
+		}
+		return false
+	}
+
+	w := post(config.AdminKey, "")
+	assertResponseOK(w, t)
+	assert.T(t, hasBan(w))
+
+	assertResponseCode(post(config.AdminKey, "{}"), http.StatusBadRequest, t)
+	w = post(config.AdminKey, `{"clear": "`+ip+`"}`)
+	assertResponseOK(w, t)
+	assert.T(t, !hasBan(w))
+}
+
+func TestRouterMethods(t *testing.T) {
+	router := createRouter()
+
+	req, err := http.NewRequest("OPTIONS", "http://testing.geobin.io/PF4C5zm67N", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assertResponseCode(w, http.StatusNoContent, t)
+	assert.Equal(t, binMethods, w.Header().Get("Allow"))
+
+	// methods that aren't for sending requests to bins are turned away before reaching one
+	req, err = http.NewRequest("TRACE", "http://testing.geobin.io/PF4C5zm67N", nil)
+	if err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	assertResponseCode(w, http.StatusMethodNotAllowed, t)
+	assert.Equal(t, binMethods, w.Header().Get("Allow"))
+}
+
+func TestStatsHandler(t *testing.T) {
+	key := config.AdminKey
+	defer func() { config.AdminKey = key }()
+	config.AdminKey = "admin-s3cr3t"
+
+	post := func(token string) *httptest.ResponseRecorder {
+		req, err := http.NewRequest("POST", "http://testing.geobin.io/api/1/stats", nil)
+		if err != nil {
+			t.Error(err)
+		}
+		if token != "" {
+			req.Header.Set(tokenHeader, token)
+		}
+		w := httptest.NewRecorder()
+		statsHandler(w, req)
+		return w
+	}
+
+	assertResponseCode(post(""), http.StatusUnauthorized, t)
+	assertResponseCode(post("wrong"), http.StatusForbidden, t)
+
+	// messages dropped by any socket are counted
+	o := newOutbox(Backpressure{Policy: dropNewest}, nil)
+	for i := 0; i < outboxSize+1; i++ {
+		o.push([]byte("{}"))
+	}
+
+	w := post(config.AdminKey)
+	assertResponseOK(w, t)
+	var stats ServerStats
+	if err := json.Unmarshal(w.Body.Bytes(), &stats); err != nil {
+		t.Error(err)
+	}
+	assert.T(t, stats.DroppedMessages >= 1)
+}
+
 /* Test Helpers */
 
 func assertResponseCode(w *httptest.ResponseRecorder, code int, t *testing.T) {
@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"time"
+)
+
+// name of the settings field marking a bin that never expires
+const pinnedSetting = "pinned"
+
+// How long bins live when the config doesn't say otherwise.
+const (
+	defaultBinTTL    = 48 * time.Hour
+	defaultMinBinTTL = time.Hour
+	defaultMaxBinTTL = 30 * 24 * time.Hour
+)
+
+// binTTLs returns how long a bin lives by default, and the least and most it may be asked to,
+// from the config.
+func binTTLs() (def, min, max time.Duration) {
+	def, min, max = defaultBinTTL, defaultMinBinTTL, defaultMaxBinTTL
+	if config.DefaultBinTTL > 0 {
+		def = time.Duration(config.DefaultBinTTL) * time.Second
+	}
+	if config.MinBinTTL > 0 {
+		min = time.Duration(config.MinBinTTL) * time.Second
+	}
+	if config.MaxBinTTL > 0 {
+		max = time.Duration(config.MaxBinTTL) * time.Second
+	}
+	return def, min, max
+}
+
+// binTTL returns how long a bin asked to live for the given number of seconds should live. With
+// 0 seconds, the default is used.
+func binTTL(seconds int64) (time.Duration, error) {
+	def, min, max := binTTLs()
+	if seconds == 0 {
+		return def, nil
+	}
+
+	d := time.Duration(seconds) * time.Second
+	if d < min || d > max {
+		return 0, fmt.Errorf("ttl must be between %d and %d seconds.", int64(min/time.Second), int64(max/time.Second))
+	}
+	return d, nil
+}
+
+// binExpires returns when the given bin expires in Unix time, or 0 if it doesn't.
+func binExpires(name string) (int64, error) {
+	ttl, err := client.TTL(name).Result()
+	if err != nil {
+		log.Println("Failure to get TTL for", name, err)
+		return 0, err
+	}
+	if ttl <= 0 {
+		return 0, nil
+	}
+	return time.Now().Add(ttl).Unix(), nil
+}
+
+// extendBin makes the given bin, and everything stored alongside it, expire d from now.
+func extendBin(name string, d time.Duration) error {
+	if res := client.Expire(name, d); res.Err() != nil {
+		log.Println("Failure to set EXPIRE for", name, res.Err())
+		return res.Err()
+	}
+
+	for _, key := range binDataKeys(name) {
+		if err := expireWithBin(name, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// isPinned returns true if the given bin never expires.
+func isPinned(name string) (bool, error) {
+	var pinned bool
+	_, err := getBinSetting(name, pinnedSetting, &pinned)
+	return pinned, err
+}
+
+// pinBin makes the given bin, and everything stored alongside it, never expire. Unpinning a bin
+// makes it expire after the default lifetime.
+func pinBin(name string, pinned bool) error {
+	if !pinned {
+		if res := client.HDel(settingsKey(name), pinnedSetting); res.Err() != nil {
+			log.Println("Failure to HDEL", pinnedSetting, "for", name, res.Err())
+			return res.Err()
+		}
+		def, _, _ := binTTLs()
+		return extendBin(name, def)
+	}
+
+	if res := client.Persist(name); res.Err() != nil {
+		log.Println("Failure to PERSIST", name, res.Err())
+		return res.Err()
+	}
+	if err := setBinSetting(name, pinnedSetting, true); err != nil {
+		return err
+	}
+	for _, key := range binDataKeys(name) {
+		if err := expireWithBin(name, key); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestBinTTL(t *testing.T) {
+	d, err := binTTL(0)
+	assert.Equal(t, nil, err)
+	def, _, _ := binTTLs()
+	assert.Equal(t, def, d)
+
+	d, err = binTTL(7200)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2*time.Hour, d)
+
+	_, err = binTTL(1)
+	assert.NotEqual(t, nil, err)
+	_, err = binTTL(365 * 24 * 60 * 60)
+	assert.NotEqual(t, nil, err)
+}
+
+func TestPinBin(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+	assert.Equal(t, nil, setBinSetting(binId, mocksSetting, MockSettings{}))
+
+	assert.Equal(t, nil, pinBin(binId, true))
+	pinned, err := isPinned(binId)
+	assert.Equal(t, nil, err)
+	assert.T(t, pinned)
+	expires, err := binExpires(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), expires)
+	// the settings are kept along with the bin
+	ttl, err := client.TTL(settingsKey(binId)).Result()
+	assert.Equal(t, nil, err)
+	assert.T(t, ttl <= 0)
+
+	assert.Equal(t, nil, pinBin(binId, false))
+	pinned, err = isPinned(binId)
+	assert.Equal(t, nil, err)
+	assert.T(t, !pinned)
+	expires, err = binExpires(binId)
+	assert.Equal(t, nil, err)
+	assert.NotEqual(t, int64(0), expires)
+}
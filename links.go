@@ -0,0 +1,226 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"time"
+
+	redis "github.com/vmihailenco/redis/v2"
+)
+
+// ShareLink lets whoever holds its token view a bin's history and live stream, but not send
+// requests to it or change its settings. It may be limited to the requests received in a window
+// of time, and expire before the bin does.
+type ShareLink struct {
+	Token   string `json:"token"`
+	Created int64  `json:"created"`
+	// Unix timestamp after which the link stops working, 0 if it lasts as long as the bin
+	Expires int64 `json:"expires,omitempty"`
+	// Unix timestamps of the first and last requests that can be seen with the link, 0 for no limit
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+}
+
+// LinkRequest is sent to /api/1/bins/{bin_id}/links to create or revoke a ShareLink.
+type LinkRequest struct {
+	// seconds the new link lasts for, 0 if it lasts as long as the bin
+	ExpiresIn int64 `json:"expiresIn,omitempty"`
+	From      int64 `json:"from,omitempty"`
+	To        int64 `json:"to,omitempty"`
+	// token of a link to revoke, instead of creating one
+	Revoke string `json:"revoke,omitempty"`
+}
+
+// LinkRevoked is published to a bin when one of its links is revoked, so that sockets opened with
+// it are closed on every server.
+type LinkRevoked struct {
+	Type  string `json:"type"` // always "link-revoked"
+	Token string `json:"token"`
+}
+
+// linksKey returns the redis key of the hash holding the share links of the given bin, by token.
+func linksKey(name string) string {
+	return "links:" + name
+}
+
+// validate checks that the expiration and the window of a new link make sense.
+func (lr LinkRequest) validate() error {
+	if lr.ExpiresIn < 0 {
+		return errors.New("expiresIn must not be negative.")
+	}
+	if lr.From < 0 || lr.To < 0 || (lr.To != 0 && lr.From > lr.To) {
+		return errors.New("from and to must be Unix timestamps, with from before to.")
+	}
+	return nil
+}
+
+// expired returns true if the link has stopped working.
+func (sl *ShareLink) expired() bool {
+	return sl.Expires != 0 && time.Now().UTC().Unix() > sl.Expires
+}
+
+// inWindow returns true if the link lets a request received at the given timestamp be seen.
+func (sl *ShareLink) inWindow(timestamp int64) bool {
+	return (sl.From == 0 || timestamp >= sl.From) && (sl.To == 0 || timestamp <= sl.To)
+}
+
+// filterWindow returns the requests in history that the link lets be seen.
+func (sl *ShareLink) filterWindow(history []*GeobinRequest) []*GeobinRequest {
+	if sl.From == 0 && sl.To == 0 {
+		return history
+	}
+
+	seen := make([]*GeobinRequest, 0, len(history))
+	for _, gr := range history {
+		if sl.inWindow(gr.Timestamp) {
+			seen = append(seen, gr)
+		}
+	}
+	return seen
+}
+
+// createLink stores a new share link for the given bin.
+func createLink(name string, lr LinkRequest) (*ShareLink, error) {
+	token, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC().Unix()
+	sl := &ShareLink{Token: token, Created: now, From: lr.From, To: lr.To}
+	if lr.ExpiresIn > 0 {
+		sl.Expires = now + lr.ExpiresIn
+	}
+
+	encoded, err := json.Marshal(sl)
+	if err != nil {
+		log.Println("Error marshalling share link:", err)
+		return nil, err
+	}
+
+	if res := client.HSet(linksKey(name), token, string(encoded)); res.Err() != nil {
+		log.Println("Failure to HSET share link for", name, res.Err())
+		return nil, res.Err()
+	}
+	return sl, expireWithBin(name, linksKey(name))
+}
+
+// getLink returns the share link of the given bin with the given token, or nil if there is none
+// or it has expired.
+func getLink(name, token string) (*ShareLink, error) {
+	if token == "" {
+		return nil, nil
+	}
+
+	res, err := client.HGet(linksKey(name), token).Result()
+	if err == redis.Nil {
+		return nil, nil
+	}
+	if err != nil {
+		log.Println("Failure to HGET share link for", name, err)
+		return nil, err
+	}
+
+	var sl ShareLink
+	if err := json.Unmarshal([]byte(res), &sl); err != nil {
+		log.Println("Error unmarshalling share link for", name, err)
+		return nil, err
+	}
+	if sl.expired() {
+		return nil, nil
+	}
+	return &sl, nil
+}
+
+// getLinks returns the share links of the given bin that haven't expired, forgetting the rest.
+func getLinks(name string) ([]*ShareLink, error) {
+	res, err := client.HGetAllMap(linksKey(name)).Result()
+	if err != nil {
+		log.Println("Failure to HGETALL share links for", name, err)
+		return nil, err
+	}
+
+	links := make([]*ShareLink, 0, len(res))
+	for token, v := range res {
+		var sl ShareLink
+		if err := json.Unmarshal([]byte(v), &sl); err != nil {
+			log.Println("Error unmarshalling share link for", name, err)
+			continue
+		}
+		if sl.expired() {
+			client.HDel(linksKey(name), token)
+			continue
+		}
+		links = append(links, &sl)
+	}
+	return links, nil
+}
+
+// revokeLink removes a share link of the given bin and closes the sockets opened with it. It
+// returns false if there was no such link.
+func revokeLink(name, token string) (bool, error) {
+	removed, err := client.HDel(linksKey(name), token).Result()
+	if err != nil {
+		log.Println("Failure to HDEL share link for", name, err)
+		return false, err
+	}
+	if removed == 0 {
+		return false, nil
+	}
+
+	payload, err := json.Marshal(LinkRevoked{Type: "link-revoked", Token: token})
+	if err != nil {
+		log.Println("Error marshalling link revocation:", err)
+		return true, err
+	}
+	if res := client.Publish(name, string(payload)); res.Err() != nil {
+		log.Println("Failure to publish link revocation for", name, res.Err())
+		return true, res.Err()
+	}
+	return true, nil
+}
+
+// withLink wraps s in a linkSocket if it was opened with a share link.
+func withLink(s Socket, link *ShareLink) Socket {
+	if link == nil {
+		return s
+	}
+	return &linkSocket{Socket: s, link: link, end: s.Close}
+}
+
+// linkSocket wraps a Socket opened with a share link. It only passes on the requests the link
+// lets be seen, and ends the subscription once the link expires or is revoked.
+type linkSocket struct {
+	Socket
+	link *ShareLink
+	// called, from its own goroutine, to end the subscription
+	end func()
+}
+
+func (ls *linkSocket) Write(payload []byte) {
+	if ls.link.expired() {
+		// the socket map may be sending to us while holding its lock, which ending needs
+		go ls.end()
+		return
+	}
+
+	var msg struct {
+		Type      string `json:"type"`
+		Token     string `json:"token"`
+		Timestamp int64  `json:"timestamp"`
+	}
+	if err := json.Unmarshal(payload, &msg); err != nil {
+		log.Println("Error unmarshalling payload for", ls.GetName(), err)
+		return
+	}
+
+	switch {
+	case msg.Type == "link-revoked":
+		if msg.Token == ls.link.Token {
+			go ls.end()
+		}
+	case msg.Type != "" || ls.link.inWindow(msg.Timestamp):
+		ls.Socket.Write(payload)
+	}
+}
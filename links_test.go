@@ -0,0 +1,87 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestLinkRequestValidate(t *testing.T) {
+	assert.Equal(t, nil, LinkRequest{}.validate())
+	assert.Equal(t, nil, LinkRequest{ExpiresIn: 60, From: 10, To: 20}.validate())
+	assert.Equal(t, nil, LinkRequest{From: 10}.validate())
+	assert.NotEqual(t, nil, LinkRequest{ExpiresIn: -1}.validate())
+	assert.NotEqual(t, nil, LinkRequest{From: 20, To: 10}.validate())
+}
+
+func TestShareLinkWindow(t *testing.T) {
+	sl := &ShareLink{From: 10, To: 20}
+	assert.T(t, !sl.inWindow(9))
+	assert.T(t, sl.inWindow(10))
+	assert.T(t, sl.inWindow(20))
+	assert.T(t, !sl.inWindow(21))
+
+	history := []*GeobinRequest{{Timestamp: 25}, {Timestamp: 15}, {Timestamp: 5}}
+	assert.Equal(t, []*GeobinRequest{{Timestamp: 15}}, sl.filterWindow(history))
+	assert.Equal(t, history, (&ShareLink{}).filterWindow(history))
+
+	assert.T(t, !(&ShareLink{}).expired())
+	assert.T(t, (&ShareLink{Expires: time.Now().Unix() - 1}).expired())
+}
+
+func TestLinks(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	sl, err := createLink(binId, LinkRequest{From: 10})
+	assert.Equal(t, nil, err)
+	found, err := getLink(binId, sl.Token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, sl, found)
+
+	links, err := getLinks(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []*ShareLink{sl}, links)
+
+	// links only let the bin's requests be viewed
+	ok, err := checkAccess(binId, sl.Token, viewAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, ok)
+	ok, err = checkAccess(binId, sl.Token, readAccess)
+	assert.Equal(t, nil, err)
+	assert.T(t, !ok)
+
+	revoked, err := revokeLink(binId, sl.Token)
+	assert.Equal(t, nil, err)
+	assert.T(t, revoked)
+	revoked, err = revokeLink(binId, sl.Token)
+	assert.Equal(t, nil, err)
+	assert.T(t, !revoked)
+
+	found, err = getLink(binId, sl.Token)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, (*ShareLink)(nil), found)
+}
+
+func TestLinkSocket(t *testing.T) {
+	ms := &MockSocket{name: "mock_socket"}
+	ended := make(chan bool, 1)
+	ls := &linkSocket{Socket: ms, link: &ShareLink{Token: "abc", From: 10, To: 20}, end: func() { ended <- true }}
+
+	ls.Write([]byte(`{"timestamp": 15}`))
+	ls.Write([]byte(`{"timestamp": 25}`))
+	ls.Write([]byte(`{"type": "presence", "event": "join", "viewers": 2}`))
+	ls.Write([]byte(`{"type": "link-revoked", "token": "other"}`))
+	assert.Equal(t, []string{`{"timestamp": 15}`, `{"type": "presence", "event": "join", "viewers": 2}`}, ms.getWritten())
+
+	ls.Write([]byte(`{"type": "link-revoked", "token": "abc"}`))
+	select {
+	case <-ended:
+	case <-time.After(time.Second):
+		t.Error("revoking the link didn't end the socket")
+	}
+	assert.Equal(t, 2, len(ms.getWritten()))
+}
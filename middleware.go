@@ -3,45 +3,76 @@ package main
 import (
 	"fmt"
 	"log"
+	"math"
 	"net/http"
 	"strconv"
 	"time"
 )
 
-// rateLimit uses redis to enforce rate limits per route. This middleware should
-// only be used on routes that contain binIds or other unique identifiers,
-// otherwise the rate limit will be globally applied, instead of scoped to a
-// particular bin.
-func rateLimit(h http.HandlerFunc, requestsPerSec int) http.HandlerFunc {
+// rateLimit enforces the config's RateLimit for the given route, see routeLimit. Buckets are
+// kept per URL path, so this middleware should only be used on routes that contain binIds or
+// other unique identifiers, otherwise the rate limit will be globally applied, instead of scoped
+// to a particular bin. If the route has one of the config's ClientRateLimits, each client IP gets
+// a bucket of its own as well, and clients that keep going over it are banned.
+//
+// Every response carries the X-RateLimit-Limit, X-RateLimit-Remaining and X-RateLimit-Reset (the
+// Unix time the bucket is full again) headers of the tightest bucket. Requests over the limit get
+// a 429 with a Retry-After header, and requests from banned clients a 403.
+func rateLimit(h http.HandlerFunc, route string) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
-		url := r.URL.Path
-		ts := time.Now().Unix()
-		key := fmt.Sprintf("rate-limit:%s:%d", url, ts)
+		now := time.Now()
+		ip := clientIP(r)
 
-		exists, err := nameExists(key)
+		ban, err := getBan(ip, now)
 		if err != nil {
-			log.Println(err)
+			log.Println("Failure to check for a ban on", ip, err)
+		}
+		if ban != nil {
+			w.Header().Set("Retry-After", fmt.Sprint(ban.Expires-now.Unix()))
+			http.Error(w, "Too many requests, you have been banned for a while.", http.StatusForbidden)
+			return
 		}
-		if exists {
-			res, err := client.Get(key).Result()
-			if err != nil {
 
-				http.Error(w, "API Error", http.StatusServiceUnavailable)
-				return
-			}
+		// the client's bucket is tried first, so that going over it doesn't use up the path's
+		buckets := make([]limitBucket, 0, 2)
+		if crl, ok := config.ClientRateLimits[route]; ok {
+			buckets = append(buckets, limitBucket{fmt.Sprintf("rate-limit:%s:ip:%s", route, ip), crl, true})
+		}
+		buckets = append(buckets, limitBucket{fmt.Sprintf("rate-limit:%s:%s", route, r.URL.Path), routeLimit(route), false})
 
-			reqCount, _ := strconv.Atoi(res)
-			if reqCount >= requestsPerSec {
-				http.Error(w, "Rate limit exceeded. Wait a moment and try again.", http.StatusInternalServerError)
-				return
+		var rl RateLimit
+		var res limitResult
+		for i, b := range buckets {
+			bres := takeToken(b.key, b.limit, now)
+			if i == 0 || !bres.allowed || bres.remaining < res.remaining {
+				rl, res = b.limit, bres
+			}
+			if !bres.allowed {
+				if b.perClient {
+					addStrike(ip, route, now)
+				}
+				break
 			}
 		}
 
-		tx := client.Multi()
-		tx.Incr(key)
-		tx.Expire(key, 5*time.Second)
-		tx.Close()
+		reset := int64(math.Ceil(float64(now.Add(res.reset).UnixNano()) / float64(time.Second)))
+		w.Header().Set("X-RateLimit-Limit", strconv.Itoa(rl.Burst))
+		w.Header().Set("X-RateLimit-Remaining", strconv.Itoa(res.remaining))
+		w.Header().Set("X-RateLimit-Reset", strconv.FormatInt(reset, 10))
+
+		if !res.allowed {
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(res.retryAfter.Seconds()))))
+			http.Error(w, "Rate limit exceeded. Wait a moment and try again.", http.StatusTooManyRequests)
+			return
+		}
 
 		h.ServeHTTP(w, r)
 	}
 }
+
+// limitBucket is one of the token buckets a request takes a token from.
+type limitBucket struct {
+	key       string
+	limit     RateLimit
+	perClient bool
+}
@@ -5,6 +5,9 @@ import (
 	"net/http"
 	"net/http/httptest"
 	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
 )
 
 func TestRateLimitMiddleware(t *testing.T) {
@@ -12,17 +15,48 @@ func TestRateLimitMiddleware(t *testing.T) {
 		fmt.Fprint(w, "ok")
 	}
 
-	limited := rateLimit(handler, 1)
+	// routes missing from the config allow 1 request per second
+	limited := rateLimit(handler, "testing")
 
-	req, _ := http.NewRequest("GET", "http://testing.geobin.io/", nil)
+	req, _ := http.NewRequest("GET", fmt.Sprint("http://testing.geobin.io/", time.Now().UnixNano()), nil)
 	wOk := httptest.NewRecorder()
 	wErr := httptest.NewRecorder()
 
 	// Rate limit is 1/s, first one should get a 200
 	limited(wOk, req)
 	assertResponseOK(wOk, t)
+	assert.Equal(t, "1", wOk.Header().Get("X-RateLimit-Limit"))
+	assert.Equal(t, "0", wOk.Header().Get("X-RateLimit-Remaining"))
 
-	// Second request should get a 500
+	// Second request should get a 429
 	limited(wErr, req)
-	assertResponseCode(wErr, 500, t)
+	assertResponseCode(wErr, http.StatusTooManyRequests, t)
+	assert.Equal(t, "1", wErr.Header().Get("Retry-After"))
+	assert.NotEqual(t, "", wErr.Header().Get("X-RateLimit-Reset"))
+}
+
+func TestBannedClient(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, "ok")
+	}
+	limited := rateLimit(handler, "testing")
+
+	ip := fmt.Sprint("banned-", time.Now().UnixNano())
+	if err := banIP(ip, "testing", time.Minute, time.Now()); err != nil {
+		t.Error(err)
+	}
+
+	req, _ := http.NewRequest("GET", fmt.Sprint("http://testing.geobin.io/", time.Now().UnixNano()), nil)
+	req.RemoteAddr = ip + ":1234"
+	w := httptest.NewRecorder()
+	limited(w, req)
+	assertResponseCode(w, http.StatusForbidden, t)
+	assert.NotEqual(t, "", w.Header().Get("Retry-After"))
+
+	if err := clearBans(BanRequest{Clear: ip}); err != nil {
+		t.Error(err)
+	}
+	w = httptest.NewRecorder()
+	limited(w, req)
+	assertResponseOK(w, t)
 }
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"path"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// name of the settings field holding a bin's mock responses
+const mocksSetting = "mocks"
+
+// longest delay a mock response may ask for, in milliseconds
+const maxMockDelay = 30000
+
+// headers mock responses may not set, on top of hopByHopHeaders. Mocks are served from the app's
+// own origin, so these could set cookies on it, send its visitors elsewhere or loosen its security.
+var mockForbiddenHeaders = map[string]bool{
+	"Set-Cookie":                          true,
+	"Set-Cookie2":                         true,
+	"Location":                            true,
+	"Refresh":                             true,
+	"Content-Security-Policy":             true,
+	"Content-Security-Policy-Report-Only": true,
+	"X-Content-Type-Options":              true,
+	"Strict-Transport-Security":           true,
+	"Access-Control-Allow-Origin":         true,
+	"Access-Control-Allow-Credentials":    true,
+}
+
+// MockMatch picks out the requests a MockRule applies to. Empty fields match anything.
+type MockMatch struct {
+	Method string `json:"method,omitempty"`
+	// glob pattern, in the syntax of path.Match, tested against the part of the URL path after
+	// the bin_id, e.g. "/orders/*"
+	Path string `json:"path,omitempty"`
+	// text that must appear in the request body
+	Body string `json:"body,omitempty"`
+}
+
+// MockRule describes the response given to requests sent to a bin.
+type MockRule struct {
+	Match   *MockMatch        `json:"match,omitempty"`
+	Status  int               `json:"status,omitempty"` // defaults to 200
+	Headers map[string]string `json:"headers,omitempty"`
+	// a text/template rendered with the fields of a mockData
+	Body string `json:"body,omitempty"`
+	// milliseconds to wait before responding
+	Delay int `json:"delay,omitempty"`
+	// percentage of requests, from 0 to 100, that get a 500 instead
+	FailureRate float64 `json:"failureRate,omitempty"`
+}
+
+// MockSettings holds the mock responses defined for a bin. The first rule that matches a request
+// is used, requests matching no rules get an empty 200.
+type MockSettings struct {
+	Rules []MockRule `json:"rules"`
+}
+
+// mockData is what the body template of a MockRule is rendered with. It holds copies of the
+// request's fields rather than the GeobinRequest itself, so that templates can't call its methods.
+type mockData struct {
+	Bin       string
+	Path      string
+	ID        string
+	Timestamp int64
+	Method    string
+	Headers   map[string]string
+	Body      string
+	Geo       []Geo
+}
+
+// newMockData returns the data the body templates of mock responses to gr are rendered with.
+func newMockData(name, subpath string, gr *GeobinRequest) mockData {
+	return mockData{
+		Bin:       name,
+		Path:      subpath,
+		ID:        gr.ID,
+		Timestamp: gr.Timestamp,
+		Method:    gr.Method,
+		Headers:   gr.Headers,
+		Body:      gr.Body,
+		Geo:       gr.Geo,
+	}
+}
+
+// validate checks that every rule has a valid status, path pattern, body template, delay and
+// failure rate.
+func (ms MockSettings) validate() error {
+	for i, rule := range ms.Rules {
+		if rule.Status != 0 && (rule.Status < 100 || rule.Status > 599) {
+			return fmt.Errorf("Rule %d has an invalid status %d.", i, rule.Status)
+		}
+		if rule.Match != nil {
+			if _, err := path.Match(rule.Match.Path, ""); err != nil {
+				return fmt.Errorf("Rule %d has an invalid path pattern: %v", i, err)
+			}
+		}
+		if _, err := template.New("body").Parse(rule.Body); err != nil {
+			return fmt.Errorf("Rule %d has an invalid body template: %v", i, err)
+		}
+		if rule.Delay < 0 || rule.Delay > maxMockDelay {
+			return fmt.Errorf("Rule %d has a delay outside of 0 to %d milliseconds.", i, maxMockDelay)
+		}
+		if rule.FailureRate < 0 || rule.FailureRate > 100 {
+			return fmt.Errorf("Rule %d has a failure rate outside of 0 to 100.", i)
+		}
+		for k := range rule.Headers {
+			if !isMockHeaderAllowed(k) {
+				return fmt.Errorf("Rule %d may not set the %s header.", i, k)
+			}
+		}
+	}
+	return nil
+}
+
+// isMockHeaderAllowed returns true if mock responses may set the named header.
+func isMockHeaderAllowed(name string) bool {
+	name = http.CanonicalHeaderKey(name)
+	return !hopByHopHeaders[name] && !mockForbiddenHeaders[name]
+}
+
+// matches returns true if the rule applies to gr, which was sent to the given sub-path of a bin.
+func (rule MockRule) matches(gr *GeobinRequest, subpath string) bool {
+	m := rule.Match
+	if m == nil {
+		return true
+	}
+	if m.Method != "" && !strings.EqualFold(m.Method, gr.Method) {
+		return false
+	}
+	if m.Path != "" {
+		if ok, _ := path.Match(m.Path, subpath); !ok {
+			return false
+		}
+	}
+	return strings.Contains(gr.Body, m.Body)
+}
+
+// getMocks returns the mock responses defined for the given bin.
+func getMocks(name string) (MockSettings, error) {
+	var ms MockSettings
+	_, err := getBinSetting(name, mocksSetting, &ms)
+	return ms, err
+}
+
+// findMock returns the first of the bin's rules that applies to gr, or nil if there are none.
+func findMock(name string, gr *GeobinRequest, subpath string) (*MockRule, error) {
+	ms, err := getMocks(name)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, rule := range ms.Rules {
+		if rule.matches(gr, subpath) {
+			return &rule, nil
+		}
+	}
+	return nil, nil
+}
+
+// writeMock waits for the rule's delay and then writes its response for gr, or a 500 if the
+// request is picked to fail.
+func writeMock(w http.ResponseWriter, rule *MockRule, name, subpath string, gr *GeobinRequest) {
+	time.Sleep(time.Duration(rule.Delay) * time.Millisecond)
+
+	if rule.FailureRate > 0 && rand.Float64()*100 < rule.FailureRate {
+		http.Error(w, "Mock failure", http.StatusInternalServerError)
+		return
+	}
+
+	var body bytes.Buffer
+	if err := renderMockBody(&body, rule.Body, newMockData(name, subpath, gr)); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	for k, v := range rule.Headers {
+		// rules saved before a header was forbidden are still held to it
+		if isMockHeaderAllowed(k) {
+			w.Header().Set(k, v)
+		}
+	}
+	// whatever the body holds, browsers must not run it on the app's origin
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "sandbox")
+	status := rule.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	w.WriteHeader(status)
+	w.Write(body.Bytes())
+}
+
+// renderMockBody renders the body template of a mock response with the given data.
+func renderMockBody(buf *bytes.Buffer, body string, data mockData) error {
+	tmpl, err := template.New("body").Parse(body)
+	if err != nil {
+		return errors.New(fmt.Sprint("Invalid mock body: ", err))
+	}
+	if err := tmpl.Execute(buf, data); err != nil {
+		return errors.New(fmt.Sprint("Error rendering mock body: ", err))
+	}
+	return nil
+}
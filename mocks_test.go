@@ -0,0 +1,90 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestMockSettingsValidate(t *testing.T) {
+	valid := MockSettings{Rules: []MockRule{
+		{Match: &MockMatch{Method: "PUT", Path: "/orders/*"}, Status: 201, Body: "{{.ID}}", Delay: 100, FailureRate: 50},
+		{},
+	}}
+	assert.Equal(t, nil, valid.validate())
+
+	invalid := []MockRule{
+		{Status: 42},
+		{Match: &MockMatch{Path: "[a-"}},
+		{Body: "{{.ID"},
+		{Delay: -1},
+		{Delay: maxMockDelay + 1},
+		{FailureRate: 101},
+		{Headers: map[string]string{"set-cookie": "session=abc"}},
+		{Headers: map[string]string{"Location": "https://example.com"}},
+		{Headers: map[string]string{"Transfer-Encoding": "chunked"}},
+	}
+	for _, rule := range invalid {
+		assert.NotEqual(t, nil, MockSettings{Rules: []MockRule{rule}}.validate())
+	}
+}
+
+func TestMockRuleMatches(t *testing.T) {
+	gr := &GeobinRequest{Method: "PUT", Body: `{"event": "order.created"}`}
+
+	tests := []struct {
+		match    *MockMatch
+		subpath  string
+		expected bool
+	}{
+		{nil, "/", true},
+		{&MockMatch{}, "/", true},
+		{&MockMatch{Method: "put"}, "/", true},
+		{&MockMatch{Method: "POST"}, "/", false},
+		{&MockMatch{Path: "/orders/*"}, "/orders/42", true},
+		{&MockMatch{Path: "/orders/*"}, "/", false},
+		{&MockMatch{Body: "order.created"}, "/", true},
+		{&MockMatch{Body: "order.deleted"}, "/", false},
+		{&MockMatch{Method: "PUT", Path: "/orders/*", Body: "order"}, "/orders/42", true},
+	}
+
+	for _, test := range tests {
+		assert.Equal(t, test.expected, MockRule{Match: test.match}.matches(gr, test.subpath), test.match, test.subpath)
+	}
+}
+
+func TestWriteMock(t *testing.T) {
+	gr := &GeobinRequest{ID: "1-2", Method: "POST", Headers: map[string]string{"X-Id": "abc"}}
+	rule := &MockRule{
+		Status:  http.StatusCreated,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    `{"bin": "{{.Bin}}", "id": "{{.ID}}", "path": "{{.Path}}", "header": "{{index .Headers "X-Id"}}"}`,
+	}
+
+	w := httptest.NewRecorder()
+	writeMock(w, rule, "bin", "/hook", gr)
+	assert.Equal(t, http.StatusCreated, w.Code)
+	assert.Equal(t, "application/json", w.Header().Get("Content-Type"))
+	assert.Equal(t, `{"bin": "bin", "id": "1-2", "path": "/hook", "header": "abc"}`, w.Body.String())
+	assert.Equal(t, "nosniff", w.Header().Get("X-Content-Type-Options"))
+	assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"))
+
+	// forbidden headers are left out of rules saved before they were forbidden
+	w = httptest.NewRecorder()
+	writeMock(w, &MockRule{Headers: map[string]string{"Set-Cookie": "session=abc", "Content-Security-Policy": "default-src *"}}, "bin", "/", gr)
+	assert.Equal(t, "", w.Header().Get("Set-Cookie"))
+	assert.Equal(t, "sandbox", w.Header().Get("Content-Security-Policy"))
+
+	// every request fails with a failure rate of 100
+	w = httptest.NewRecorder()
+	writeMock(w, &MockRule{FailureRate: 100}, "bin", "/", gr)
+	assert.Equal(t, http.StatusInternalServerError, w.Code)
+
+	// and none do without one
+	w = httptest.NewRecorder()
+	writeMock(w, &MockRule{}, "bin", "/", gr)
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, "", w.Body.String())
+}
@@ -0,0 +1,113 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"time"
+)
+
+const (
+	// how long a poll waits for new requests when it doesn't say otherwise, in seconds
+	defaultPollTimeout = 30
+	// longest a poll may wait for new requests, in seconds
+	maxPollTimeout = 60
+	// most requests returned by a single poll
+	maxPollBatch = 100
+)
+
+// PollOptions describes what a long-poll waits for.
+type PollOptions struct {
+	// ID of the last request the client has seen, requests after it are returned
+	Cursor string `json:"cursor"`
+	// seconds to wait for new requests before returning an empty batch
+	Timeout int `json:"timeout"`
+}
+
+// PollResult is a batch of requests returned by a long-poll, along with the cursor to pass to the
+// next poll.
+type PollResult struct {
+	Requests []*GeobinRequest `json:"requests"`
+	Cursor   string           `json:"cursor"`
+}
+
+// validate checks the cursor and timeout, filling in the default timeout if none was given.
+func (po *PollOptions) validate() error {
+	if po.Cursor != "" {
+		if _, _, ok := parseRequestID(po.Cursor); !ok {
+			return errors.New("cursor must be the id of a request.")
+		}
+	}
+
+	if po.Timeout == 0 {
+		po.Timeout = defaultPollTimeout
+	}
+	if po.Timeout < 0 || po.Timeout > maxPollTimeout {
+		return fmt.Errorf("timeout must be between 1 and %d seconds.", maxPollTimeout)
+	}
+	return nil
+}
+
+// pollSocket is a Socket that only notes that something was published to its bin, waking up the
+// long-poll waiting on it.
+type pollSocket struct {
+	name string
+	wake chan bool
+}
+
+// newPollSocket returns a pollSocket with the given name.
+func newPollSocket(name string) *pollSocket {
+	return &pollSocket{
+		name: name,
+		wake: make(chan bool, 1),
+	}
+}
+
+func (ps *pollSocket) Write(payload []byte) {
+	// a wake up that hasn't been handled yet covers this one too
+	select {
+	case ps.wake <- true:
+	default:
+	}
+}
+
+func (ps *pollSocket) GetName() string {
+	return ps.name
+}
+
+func (ps *pollSocket) Close() {}
+
+// poll returns the requests to the given bin after po.Cursor. If there are none yet, it waits for
+// ps to be woken up by new ones until po.Timeout passes or `gone` is closed. With no cursor, only
+// requests that arrive while it waits are returned.
+func poll(name string, po PollOptions, ps *pollSocket, gone <-chan struct{}) (*PollResult, error) {
+	cursor := po.Cursor
+	if cursor == "" {
+		cursor = newRequestID(time.Now().UTC().Unix())
+	}
+	result := &PollResult{Requests: make([]*GeobinRequest, 0), Cursor: cursor}
+
+	timeout := time.After(time.Duration(po.Timeout) * time.Second)
+	for {
+		batch, err := getHistorySince(name, 0, cursor)
+		if err != nil {
+			return nil, err
+		}
+
+		if len(batch) > 0 {
+			if len(batch) > maxPollBatch {
+				batch = batch[:maxPollBatch]
+			}
+			result.Requests = batch
+			result.Cursor = batch[len(batch)-1].ID
+			return result, nil
+		}
+
+		select {
+		case <-ps.wake:
+		case <-timeout:
+			return result, nil
+		case <-gone:
+			return result, nil
+		}
+	}
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPollOptionsValidate(t *testing.T) {
+	po := PollOptions{}
+	assert.Equal(t, nil, po.validate())
+	assert.Equal(t, defaultPollTimeout, po.Timeout)
+
+	po = PollOptions{Cursor: newRequestID(1), Timeout: 5}
+	assert.Equal(t, nil, po.validate())
+	assert.Equal(t, 5, po.Timeout)
+
+	for _, po := range []PollOptions{
+		{Cursor: "abc"},
+		{Timeout: -1},
+		{Timeout: maxPollTimeout + 1},
+	} {
+		assert.NotEqual(t, nil, po.validate(), po)
+	}
+}
+
+func TestPollSocket(t *testing.T) {
+	ps := newPollSocket("test_socket")
+	assert.Equal(t, "test_socket", ps.GetName())
+
+	// any number of writes wake the poll up once
+	ps.Write([]byte("one"))
+	ps.Write([]byte("two"))
+	assert.Equal(t, 1, len(ps.wake))
+}
+
+func TestPoll(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	if _, err := postToBin(binId, `{"lat": 10, "lng": -10}`); err != nil {
+		t.Error(err)
+	}
+	history, err := getHistory(binId)
+	if err != nil {
+		t.Error(err)
+	}
+
+	// requests after the cursor are returned right away
+	result, err := poll(binId, PollOptions{Cursor: newRequestID(0), Timeout: 1}, newPollSocket("test_socket"), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(result.Requests))
+	assert.Equal(t, history[0].ID, result.Cursor)
+
+	// nothing after the last one, so the poll times out
+	start := time.Now()
+	result, err = poll(binId, PollOptions{Cursor: history[0].ID, Timeout: 1}, newPollSocket("test_socket"), nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(result.Requests))
+	assert.Equal(t, history[0].ID, result.Cursor)
+	assert.T(t, time.Since(start) >= time.Second)
+
+	// a new request wakes the poll up
+	ps := newPollSocket("test_socket")
+	go func() {
+		time.Sleep(50 * time.Millisecond)
+		postToBin(binId, `{"lat": 20, "lng": -20}`)
+		ps.Write(nil)
+	}()
+	result, err = poll(binId, PollOptions{Cursor: history[0].ID, Timeout: 5}, ps, nil)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 1, len(result.Requests))
+	assert.Equal(t, `{"lat": 20, "lng": -20}`, result.Requests[0].Body)
+}
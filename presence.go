@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	redis "github.com/vmihailenco/redis/v2"
+)
+
+const (
+	// how often each server refreshes the viewers it is connected to
+	presenceRefresh = 20 * time.Second
+	// viewers not refreshed for this long are assumed to be gone, e.g. because their server died
+	presenceTimeout = 3 * presenceRefresh
+)
+
+// PresenceNotice is published to a bin whenever a viewer starts or stops watching it.
+type PresenceNotice struct {
+	Type    string `json:"type"`  // always "presence"
+	Event   string `json:"event"` // "join" or "leave"
+	Viewers int64  `json:"viewers"`
+}
+
+// viewersKey returns the redis key of the sorted set holding the sockets watching the given bin,
+// on every server, scored by when they were last refreshed.
+func viewersKey(name string) string {
+	return "viewers:" + name
+}
+
+// presenceMap wraps a SocketMap, keeping track of the viewers of each bin in redis and publishing
+// a PresenceNotice to the bin whenever one joins or leaves.
+type presenceMap struct {
+	SocketMap
+
+	lk sync.Mutex
+	// the sockets on this server watching each bin
+	local map[string]map[string]bool
+}
+
+// newPresenceMap wraps sm to keep track of viewers.
+func newPresenceMap(sm SocketMap) *presenceMap {
+	return &presenceMap{
+		SocketMap: sm,
+		local:     make(map[string]map[string]bool),
+	}
+}
+
+func (pm *presenceMap) Add(binName, socketUUID string, s Socket, f *StreamFilter) {
+	_, existed := pm.SocketMap.Get(binName, socketUUID)
+	pm.SocketMap.Add(binName, socketUUID, s, f)
+
+	// long-polls come and go with every batch, so they aren't counted as viewers
+	if _, ok := s.(*pollSocket); existed || ok {
+		return
+	}
+
+	pm.lk.Lock()
+	if _, ok := pm.local[binName]; !ok {
+		pm.local[binName] = make(map[string]bool)
+	}
+	pm.local[binName][socketUUID] = true
+	pm.lk.Unlock()
+
+	now := time.Now().UTC().Unix()
+	if res := client.ZAdd(viewersKey(binName), redis.Z{Score: float64(now), Member: socketUUID}); res.Err() != nil {
+		log.Println("Failure to ZADD viewer for", binName, res.Err())
+		return
+	}
+	client.Expire(viewersKey(binName), presenceTimeout)
+	publishPresence(binName, "join")
+}
+
+func (pm *presenceMap) Delete(binName, socketUUID string) error {
+	if err := pm.SocketMap.Delete(binName, socketUUID); err != nil {
+		return err
+	}
+	pm.leave(binName, socketUUID)
+	return nil
+}
+
+func (pm *presenceMap) DeleteAll(socketUUID string) error {
+	bins := pm.SocketMap.Bins(socketUUID)
+	err := pm.SocketMap.DeleteAll(socketUUID)
+	for _, binName := range bins {
+		pm.leave(binName, socketUUID)
+	}
+	return err
+}
+
+func (pm *presenceMap) DeleteBin(binName string) []Socket {
+	// the bin's viewers went with it
+	pm.lk.Lock()
+	delete(pm.local, binName)
+	pm.lk.Unlock()
+	return pm.SocketMap.DeleteBin(binName)
+}
+
+// leave removes a socket from the viewers of a bin, if it was one.
+func (pm *presenceMap) leave(binName, socketUUID string) {
+	pm.lk.Lock()
+	if !pm.local[binName][socketUUID] {
+		pm.lk.Unlock()
+		return
+	}
+	delete(pm.local[binName], socketUUID)
+	if len(pm.local[binName]) == 0 {
+		delete(pm.local, binName)
+	}
+	pm.lk.Unlock()
+
+	if res := client.ZRem(viewersKey(binName), socketUUID); res.Err() != nil {
+		log.Println("Failure to ZREM viewer for", binName, res.Err())
+		return
+	}
+	publishPresence(binName, "leave")
+}
+
+// refresh marks every socket on this server as still watching its bins.
+func (pm *presenceMap) refresh() {
+	pm.lk.Lock()
+	members := make(map[string][]redis.Z)
+	now := float64(time.Now().UTC().Unix())
+	for binName, sockets := range pm.local {
+		for socketUUID := range sockets {
+			members[binName] = append(members[binName], redis.Z{Score: now, Member: socketUUID})
+		}
+	}
+	pm.lk.Unlock()
+
+	for binName, m := range members {
+		if res := client.ZAdd(viewersKey(binName), m...); res.Err() != nil {
+			log.Println("Failure to refresh viewers for", binName, res.Err())
+			continue
+		}
+		client.Expire(viewersKey(binName), presenceTimeout)
+	}
+}
+
+// presencePump refreshes the viewers on this server until the server stops.
+func (pm *presenceMap) presencePump() {
+	ticker := time.NewTicker(presenceRefresh)
+	defer ticker.Stop()
+	for range ticker.C {
+		pm.refresh()
+	}
+}
+
+// countViewers returns the number of sockets watching the given bin across every server.
+func countViewers(name string) (int64, error) {
+	stale := fmt.Sprint(time.Now().UTC().Add(-presenceTimeout).Unix())
+	if res := client.ZRemRangeByScore(viewersKey(name), "-inf", "("+stale); res.Err() != nil {
+		log.Println("Failure to remove stale viewers for", name, res.Err())
+		return 0, res.Err()
+	}
+
+	count, err := client.ZCount(viewersKey(name), "-inf", "+inf").Result()
+	if err != nil {
+		log.Println("Failure to ZCOUNT viewers for", name, err)
+		return 0, err
+	}
+	return count, nil
+}
+
+// publishPresence tells everyone watching the given bin, on every server, that a viewer joined or
+// left and how many there are now.
+func publishPresence(name, event string) {
+	viewers, err := countViewers(name)
+	if err != nil {
+		return
+	}
+
+	payload, err := json.Marshal(PresenceNotice{Type: "presence", Event: event, Viewers: viewers})
+	if err != nil {
+		log.Println("Error marshalling presence for", name, err)
+		return
+	}
+
+	if res := client.Publish(name, string(payload)); res.Err() != nil {
+		log.Println("Failure to publish presence for", name, res.Err())
+	}
+}
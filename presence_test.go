@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPresenceMap(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	pm := newPresenceMap(NewSocketMap(nil))
+	ms1 := &MockSocket{name: "mock_socket1"}
+	ms2 := &MockSocket{name: "mock_socket2"}
+
+	pm.Add(binId, "socket_uuid1", ms1, nil)
+	pm.Add(binId, "socket_uuid2", ms2, nil)
+	// adding a socket again doesn't count it twice
+	pm.Add(binId, "socket_uuid2", ms2, nil)
+
+	viewers, err := countViewers(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), viewers)
+	assert.Equal(t, map[string]map[string]bool{binId: {"socket_uuid1": true, "socket_uuid2": true}}, pm.local)
+
+	// refreshing keeps the same viewers
+	pm.refresh()
+	viewers, err = countViewers(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(2), viewers)
+
+	assert.Equal(t, nil, pm.Delete(binId, "socket_uuid1"))
+	assert.NotEqual(t, nil, pm.Delete(binId, "socket_uuid1"))
+	assert.Equal(t, nil, pm.DeleteAll("socket_uuid2"))
+
+	viewers, err = countViewers(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(0), viewers)
+	assert.Equal(t, map[string]map[string]bool{}, pm.local)
+}
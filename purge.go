@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"strings"
+)
+
+// PurgeOptions picks out the requests to remove from a bin. Without either bound, every request
+// is removed.
+type PurgeOptions struct {
+	// Unix timestamps of the oldest and newest requests to remove, 0 for no limit
+	From int64 `json:"from,omitempty"`
+	To   int64 `json:"to,omitempty"`
+}
+
+// validate checks that the bounds are timestamps in order.
+func (po PurgeOptions) validate() error {
+	if po.From < 0 || po.To < 0 || (po.To != 0 && po.From > po.To) {
+		return errors.New("from and to must be Unix timestamps, with from before to.")
+	}
+	return nil
+}
+
+// purgeRequests removes the requests picked out by po from the given bin, keeping the bin itself,
+// and tells anyone watching the bin how many were removed.
+func purgeRequests(name string, po PurgeOptions) (int64, error) {
+	var removed int64
+	var err error
+	if po.From == 0 && po.To == 0 {
+		// everything but the placeholder from when the bin was created
+		removed, err = client.ZRemRangeByRank(name, 1, -1).Result()
+	} else {
+		// requests are never scored below 1, which keeps the placeholder out of range
+		from, to := "1", "+inf"
+		if po.From > 1 {
+			from = fmt.Sprint(po.From)
+		}
+		if po.To != 0 {
+			to = fmt.Sprint(po.To)
+		}
+		removed, err = client.ZRemRangeByScore(name, from, to).Result()
+	}
+	if err != nil {
+		log.Println("Failure to remove requests from", name, err)
+		return 0, err
+	}
+
+	publishNotice(name, SocketNotice{Type: "purged", Bin: name, Count: int(removed)})
+	return removed, nil
+}
+
+// publishNotice publishes a SocketNotice to everyone watching the given bin, on every server.
+func publishNotice(name string, notice SocketNotice) {
+	payload, err := json.Marshal(notice)
+	if err != nil {
+		log.Println("Error marshalling socket notice:", err)
+		return
+	}
+	if res := client.Publish(name, string(payload)); res.Err() != nil {
+		log.Println("Failure to publish", notice.Type, "notice for", name, res.Err())
+	}
+}
+
+// dropBin unsubscribes every socket on this server from a bin that was deleted. Sockets opened
+// for that bin alone are closed, multiplexed sockets stay open for their other bins.
+func dropBin(name string) {
+	for _, s := range socketMap.DeleteBin(name) {
+		if strings.HasPrefix(s.GetName(), name+"~br~") {
+			s.Close()
+		}
+	}
+}
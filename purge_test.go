@@ -0,0 +1,66 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestPurgeOptionsValidate(t *testing.T) {
+	assert.Equal(t, nil, PurgeOptions{}.validate())
+	assert.Equal(t, nil, PurgeOptions{From: 10}.validate())
+	assert.Equal(t, nil, PurgeOptions{From: 10, To: 20}.validate())
+	assert.NotEqual(t, nil, PurgeOptions{From: 20, To: 10}.validate())
+	assert.NotEqual(t, nil, PurgeOptions{To: -1}.validate())
+}
+
+func TestPurgeRequests(t *testing.T) {
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	for _, ts := range []int64{100, 200, 300} {
+		if _, err := storeRequest(binId, NewGeobinRequest(ts, map[string]string{}, []byte("{}"))); err != nil {
+			t.Error(err)
+		}
+	}
+
+	removed, err := purgeRequests(binId, PurgeOptions{From: 150, To: 250})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), removed)
+	history, err := getHistory(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 2, len(history))
+
+	removed, err = purgeRequests(binId, PurgeOptions{To: 150})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), removed)
+
+	// clearing everything keeps the bin
+	removed, err = purgeRequests(binId, PurgeOptions{})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, int64(1), removed)
+	exists, err := nameExists(binId)
+	assert.Equal(t, nil, err)
+	assert.T(t, exists)
+	history, err = getHistory(binId)
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(history))
+}
+
+func TestDropBin(t *testing.T) {
+	sm := socketMap
+	defer func() { socketMap = sm }()
+	socketMap = NewSocketMap(nil)
+
+	single := &MockSocket{name: "bin_a~br~single_uuid"}
+	mux := &MockSocket{name: "mux_uuid"}
+	socketMap.Add("bin_a", "single_uuid", single, nil)
+	socketMap.Add("bin_a", "mux_uuid", mux, nil)
+	socketMap.Add("bin_b", "mux_uuid", mux, nil)
+
+	dropBin("bin_a")
+	assert.Equal(t, []string{}, socketMap.Bins("single_uuid"))
+	assert.Equal(t, []string{"bin_b"}, socketMap.Bins("mux_uuid"))
+}
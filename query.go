@@ -0,0 +1,125 @@
+package main
+
+import (
+	"errors"
+	"sort"
+)
+
+// number of segments used to approximate the circle of a point and radius query
+const querySegments = 64
+
+// SpatialQuery describes an area to search a bin's history for. Exactly one of BBox, Point
+// (along with Radius) or Polygon should be given.
+type SpatialQuery struct {
+	BBox    []float64              `json:"bbox,omitempty"`    // [minLng, minLat, maxLng, maxLat]
+	Point   []float64              `json:"point,omitempty"`   // [lng, lat]
+	Radius  float64                `json:"radius,omitempty"`  // in meters
+	Polygon map[string]interface{} `json:"polygon,omitempty"` // GeoJSON Polygon, MultiPolygon, Feature or FeatureCollection
+}
+
+// QueryResult is a stored request that matched a SpatialQuery, along with the paths to the
+// matching items in its Geo.
+type QueryResult struct {
+	Request *GeobinRequest  `json:"request"`
+	Paths   [][]interface{} `json:"paths"`
+}
+
+// area returns the shape described by the query.
+func (q SpatialQuery) area() (shape, error) {
+	given := 0
+	for _, ok := range []bool{q.BBox != nil, q.Point != nil, q.Polygon != nil} {
+		if ok {
+			given++
+		}
+	}
+	if given != 1 {
+		return shape{}, errors.New("Exactly one of bbox, point or polygon is required.")
+	}
+
+	switch {
+	case q.BBox != nil:
+		if len(q.BBox) != 4 || q.BBox[0] > q.BBox[2] || q.BBox[1] > q.BBox[3] {
+			return shape{}, errors.New("bbox must be [minLng, minLat, maxLng, maxLat].")
+		}
+		return rectShape(rect{q.BBox[0], q.BBox[1], q.BBox[2], q.BBox[3]}), nil
+	case q.Point != nil:
+		if len(q.Point) != 2 || !lngIsValid(q.Point[0]) || !latIsValid(q.Point[1]) {
+			return shape{}, errors.New("point must be [lng, lat].")
+		}
+		if q.Radius <= 0 {
+			return shape{}, errors.New("radius must be greater than 0.")
+		}
+		return toShape(circlePolygon(q.Point[0], q.Point[1], q.Radius, querySegments)), nil
+	default:
+		s := toShape(q.Polygon)
+		if len(s.polygons) == 0 || len(s.points) > 0 || len(s.lines) > 0 {
+			return shape{}, errors.New("polygon must be a GeoJSON Polygon or MultiPolygon.")
+		}
+		return s, nil
+	}
+}
+
+// matches returns true if the given shape falls within the query's area
+func (q SpatialQuery) matches(s shape, area shape) bool {
+	if q.Point == nil {
+		return s.intersects(area)
+	}
+
+	// points are checked against the real circle rather than the polygon approximating it
+	for _, p := range s.points {
+		if distance(q.Point[0], q.Point[1], p[0], p[1]) <= q.Radius {
+			return true
+		}
+	}
+	s.points = nil
+	return s.intersects(area)
+}
+
+// queryHistory returns the requests in history with any Geo that matches the query, in the
+// same order as history. Each Geo whose bounding box overlaps the query's is checked against the
+// exact shape of the query. Fence events are left out.
+func queryHistory(history []*GeobinRequest, q SpatialQuery) ([]QueryResult, error) {
+	area, err := q.area()
+	if err != nil {
+		return nil, err
+	}
+	areaBounds, _ := area.bounds()
+
+	matched := make(map[int][]int)
+	for i, gr := range history {
+		// fence events carry the Geo of the request that triggered them, which is already here
+		if gr.Event != nil {
+			continue
+		}
+		for j, g := range gr.Geo {
+			s := toShape(g.Geo)
+			b, ok := s.bounds()
+			if !ok || !b.intersects(areaBounds) {
+				continue
+			}
+			if q.matches(s, area) {
+				matched[i] = append(matched[i], j)
+			}
+		}
+	}
+
+	requests := make([]int, 0, len(matched))
+	for i := range matched {
+		requests = append(requests, i)
+	}
+	sort.Ints(requests)
+
+	results := make([]QueryResult, 0, len(requests))
+	for _, i := range requests {
+		geos := matched[i]
+		sort.Ints(geos)
+
+		paths := make([][]interface{}, 0, len(geos))
+		for _, j := range geos {
+			paths = append(paths, history[i].Geo[j].Path)
+		}
+		results = append(results, QueryResult{Request: history[i], Paths: paths})
+	}
+
+	return results, nil
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func queryTestHistory() []*GeobinRequest {
+	return []*GeobinRequest{
+		NewGeobinRequest(3, nil, []byte(`{"lat": 45.5, "lng": -122.5}`)),
+		NewGeobinRequest(2, nil, []byte(`{"type": "LineString", "coordinates": [[-123, 45.6], [-122, 45.6]]}`)),
+		NewGeobinRequest(1, nil, []byte(`{"a": {"lat": 10, "lng": 10}, "b": {"lat": 45.51, "lng": -122.51}}`)),
+		NewGeobinRequest(0, nil, []byte(`{"nothing": "here"}`)),
+	}
+}
+
+func queryTimestamps(results []QueryResult) []int64 {
+	ts := make([]int64, len(results))
+	for i, r := range results {
+		ts[i] = r.Request.Timestamp
+	}
+	return ts
+}
+
+func TestQueryHistoryBBox(t *testing.T) {
+	results, err := queryHistory(queryTestHistory(), SpatialQuery{BBox: []float64{-123, 45, -122, 46}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int64{3, 2, 1}, queryTimestamps(results))
+
+	// only the matching geo path is returned
+	assert.Equal(t, [][]interface{}{{"b"}}, results[2].Paths)
+
+	results, err = queryHistory(queryTestHistory(), SpatialQuery{BBox: []float64{0, 0, 1, 1}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, 0, len(results))
+}
+
+func TestQueryHistorySkipsEvents(t *testing.T) {
+	history := queryTestHistory()
+	event := &GeobinRequest{Timestamp: 4, Geo: history[0].Geo, Event: &FenceEvent{Type: "enter", Fence: "a"}}
+	history = append([]*GeobinRequest{event}, history...)
+
+	results, err := queryHistory(history, SpatialQuery{BBox: []float64{-123, 45, -122, 46}})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int64{3, 2, 1}, queryTimestamps(results))
+}
+
+func TestQueryHistoryRadius(t *testing.T) {
+	// 45.5,-122.5 and 45.51,-122.51 are roughly 1.36km apart
+	results, err := queryHistory(queryTestHistory(), SpatialQuery{Point: []float64{-122.5, 45.5}, Radius: 1000})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int64{3}, queryTimestamps(results))
+
+	results, err = queryHistory(queryTestHistory(), SpatialQuery{Point: []float64{-122.5, 45.5}, Radius: 1500})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int64{3, 1}, queryTimestamps(results))
+
+	// the line is about 11km north
+	results, err = queryHistory(queryTestHistory(), SpatialQuery{Point: []float64{-122.5, 45.5}, Radius: 12000})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int64{3, 2, 1}, queryTimestamps(results))
+}
+
+func TestQueryHistoryPolygon(t *testing.T) {
+	polygon := map[string]interface{}{
+		"type": "Feature",
+		"geometry": map[string]interface{}{
+			"type": "Polygon",
+			"coordinates": []interface{}{[]interface{}{
+				[]interface{}{float64(9), float64(9)},
+				[]interface{}{float64(11), float64(9)},
+				[]interface{}{float64(11), float64(11)},
+				[]interface{}{float64(9), float64(11)},
+				[]interface{}{float64(9), float64(9)},
+			}},
+		},
+	}
+
+	results, err := queryHistory(queryTestHistory(), SpatialQuery{Polygon: polygon})
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []int64{1}, queryTimestamps(results))
+	assert.Equal(t, [][]interface{}{{"a"}}, results[0].Paths)
+}
+
+func TestQueryHistoryInvalid(t *testing.T) {
+	for _, q := range []SpatialQuery{
+		{},
+		{BBox: []float64{1, 2, 3}},
+		{BBox: []float64{3, 2, 1, 0}},
+		{Point: []float64{1, 2}},
+		{Point: []float64{200, 2}, Radius: 10},
+		{Polygon: map[string]interface{}{"type": "Point", "coordinates": []interface{}{float64(1), float64(2)}}},
+		{BBox: []float64{0, 0, 1, 1}, Point: []float64{1, 2}, Radius: 10},
+	} {
+		_, err := queryHistory(queryTestHistory(), q)
+		assert.NotEqual(t, nil, err)
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"log"
+	"math"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// RateLimit is a token bucket. It holds up to Burst tokens, refilled at Rate tokens per second,
+// and every request takes one.
+type RateLimit struct {
+	// requests allowed per second, on average
+	Rate float64
+	// requests allowed at once, after a quiet spell
+	Burst int
+}
+
+// the limit of routes missing from the config's RateLimits, one request per second
+var defaultRateLimit = RateLimit{Rate: 1, Burst: 1}
+
+// most buckets kept in memory while redis can't be reached, before full ones are dropped
+const maxLocalBuckets = 10000
+
+// takes a token from the bucket in KEYS[1], refilling it for the time since it was last used.
+// Returns whether a token was taken, and the tokens left as a string so that fractions survive.
+var takeTokenScript = `
+local rate = tonumber(ARGV[1])
+local burst = tonumber(ARGV[2])
+local now = tonumber(ARGV[3])
+local bucket = redis.call('HMGET', KEYS[1], 'tokens', 'updated')
+local tokens = tonumber(bucket[1]) or burst
+local updated = tonumber(bucket[2]) or now
+tokens = math.min(burst, tokens + math.max(0, now - updated) * rate / 1000)
+local allowed = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+	allowed = 1
+end
+redis.call('HMSET', KEYS[1], 'tokens', tostring(tokens), 'updated', now)
+redis.call('PEXPIRE', KEYS[1], math.ceil(burst / rate * 1000))
+return {allowed, tostring(tokens)}`
+
+// limitResult describes a request's turn at a RateLimit.
+type limitResult struct {
+	allowed bool
+	// whole tokens left in the bucket
+	remaining int
+	// how long until a token is available, if the request wasn't allowed
+	retryAfter time.Duration
+	// how long until the bucket is full again
+	reset time.Duration
+}
+
+// validate checks that the bucket can be refilled and holds at least one token.
+func (rl RateLimit) validate() error {
+	if rl.Rate <= 0 {
+		return errors.New("Rate must be positive.")
+	}
+	if rl.Burst < 1 {
+		return errors.New("Burst must be at least 1.")
+	}
+	return nil
+}
+
+// routeLimit returns the config's RateLimit for the given route, or defaultRateLimit.
+func routeLimit(route string) RateLimit {
+	if rl, ok := config.RateLimits[route]; ok {
+		return rl
+	}
+	return defaultRateLimit
+}
+
+// result describes the bucket once a request has had its turn, leaving `tokens` tokens.
+func (rl RateLimit) result(allowed bool, tokens float64) limitResult {
+	res := limitResult{
+		allowed:   allowed,
+		remaining: int(tokens),
+		reset:     time.Duration((float64(rl.Burst) - tokens) / rl.Rate * float64(time.Second)),
+	}
+	if !allowed {
+		res.retryAfter = time.Duration((1 - tokens) / rl.Rate * float64(time.Second))
+	}
+	return res
+}
+
+// takeToken takes a token from the bucket with the given key, shared by every server. If redis
+// can't be reached, a bucket kept by this server alone is used.
+func takeToken(key string, rl RateLimit, now time.Time) limitResult {
+	ms := now.UnixNano() / int64(time.Millisecond)
+	res := client.Eval(takeTokenScript, []string{key}, []string{fmt.Sprint(rl.Rate), fmt.Sprint(rl.Burst), fmt.Sprint(ms)})
+	if res.Err() == nil {
+		if vals, ok := res.Val().([]interface{}); ok && len(vals) == 2 {
+			allowed, _ := vals[0].(int64)
+			s, _ := vals[1].(string)
+			if tokens, err := strconv.ParseFloat(s, 64); err == nil {
+				return rl.result(allowed == 1, tokens)
+			}
+		}
+		log.Println("Unexpected rate limit reply for", key, res.Val())
+	} else {
+		log.Println("Failure to take rate limit token for", key, res.Err())
+	}
+
+	allowed, tokens := localBuckets.take(key, rl, now)
+	return rl.result(allowed, tokens)
+}
+
+// localLimiter keeps token buckets in memory, for when redis can't be reached.
+type localLimiter struct {
+	sync.Mutex
+	buckets map[string]*localBucket
+}
+
+type localBucket struct {
+	tokens  float64
+	updated time.Time
+}
+
+var localBuckets = &localLimiter{buckets: make(map[string]*localBucket)}
+
+// take takes a token from the bucket with the given key, refilling it for the time since it was
+// last used. It returns whether a token was taken and the tokens left.
+func (ll *localLimiter) take(key string, rl RateLimit, now time.Time) (bool, float64) {
+	ll.Lock()
+	defer ll.Unlock()
+
+	b, ok := ll.buckets[key]
+	if !ok {
+		if len(ll.buckets) >= maxLocalBuckets {
+			ll.prune(rl, now)
+		}
+		b = &localBucket{tokens: float64(rl.Burst), updated: now}
+		ll.buckets[key] = b
+	}
+
+	if elapsed := now.Sub(b.updated).Seconds(); elapsed > 0 {
+		b.tokens = math.Min(float64(rl.Burst), b.tokens+elapsed*rl.Rate)
+	}
+	b.updated = now
+
+	if b.tokens < 1 {
+		return false, b.tokens
+	}
+	b.tokens--
+	return true, b.tokens
+}
+
+// prune drops the buckets that would be full by now, which are no different from new ones.
+func (ll *localLimiter) prune(rl RateLimit, now time.Time) {
+	for key, b := range ll.buckets {
+		if b.tokens+now.Sub(b.updated).Seconds()*rl.Rate >= float64(rl.Burst) {
+			delete(ll.buckets, key)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestRateLimitValidate(t *testing.T) {
+	assert.Equal(t, nil, RateLimit{Rate: 0.5, Burst: 1}.validate())
+	assert.NotEqual(t, nil, RateLimit{Rate: 0, Burst: 1}.validate())
+	assert.NotEqual(t, nil, RateLimit{Rate: 1, Burst: 0}.validate())
+}
+
+func TestRateLimitResult(t *testing.T) {
+	rl := RateLimit{Rate: 2, Burst: 4}
+
+	res := rl.result(true, 1.5)
+	assert.T(t, res.allowed)
+	assert.Equal(t, 1, res.remaining)
+	assert.Equal(t, time.Duration(0), res.retryAfter)
+	assert.Equal(t, 1250*time.Millisecond, res.reset)
+
+	res = rl.result(false, 0.5)
+	assert.T(t, !res.allowed)
+	assert.Equal(t, 0, res.remaining)
+	assert.Equal(t, 250*time.Millisecond, res.retryAfter)
+}
+
+func TestLocalLimiter(t *testing.T) {
+	ll := &localLimiter{buckets: make(map[string]*localBucket)}
+	rl := RateLimit{Rate: 1, Burst: 2}
+	now := time.Now()
+
+	allowed, tokens := ll.take("a", rl, now)
+	assert.T(t, allowed)
+	assert.Equal(t, 1.0, tokens)
+	allowed, _ = ll.take("a", rl, now)
+	assert.T(t, allowed)
+	allowed, tokens = ll.take("a", rl, now)
+	assert.T(t, !allowed)
+	assert.Equal(t, 0.0, tokens)
+
+	// other keys have their own bucket
+	allowed, _ = ll.take("b", rl, now)
+	assert.T(t, allowed)
+
+	// half a second refills half a token
+	allowed, tokens = ll.take("a", rl, now.Add(500*time.Millisecond))
+	assert.T(t, !allowed)
+	assert.Equal(t, 0.5, tokens)
+	allowed, _ = ll.take("a", rl, now.Add(time.Second))
+	assert.T(t, allowed)
+
+	// buckets that have refilled are pruned
+	ll.prune(rl, now.Add(time.Minute))
+	assert.Equal(t, 0, len(ll.buckets))
+}
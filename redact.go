@@ -0,0 +1,267 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// name of the settings field holding a bin's redaction rules
+const redactionSetting = "redaction"
+
+// what redacted values are replaced with
+const redactedValue = "[REDACTED]"
+
+// RedactionRules pick out the parts of the requests sent to a bin that must never be stored, like
+// credentials and personal details. The config's rules apply to every bin, along with its own.
+type RedactionRules struct {
+	// names of headers whose values are removed, like "Authorization"
+	Headers []string `json:"headers,omitempty"`
+	// names of query parameters whose values are removed, from URLs in headers, like Referer, and
+	// from form bodies. The query string of the request itself isn't stored, so needs no rules.
+	Query []string `json:"query,omitempty"`
+	// JSONPaths of values removed from JSON bodies, like "$.user.email"
+	Paths []string `json:"paths,omitempty"`
+	// regular expressions whose matches are removed from header values and bodies
+	Patterns []string `json:"patterns,omitempty"`
+}
+
+// redactor applies a set of RedactionRules.
+type redactor struct {
+	headers  []string
+	query    []string
+	paths    []redactionPath
+	patterns []*regexp.Regexp
+}
+
+// redactionPath is a JSONPath of RedactionRules.Paths, parsed into keys suitable for valueAtPath.
+type redactionPath struct {
+	expr string
+	keys []string
+}
+
+// validate checks that every rule can be applied.
+func (rr RedactionRules) validate() error {
+	_, err := rr.compile()
+	return err
+}
+
+// compile parses the paths and patterns of the rules.
+func (rr RedactionRules) compile() (*redactor, error) {
+	rd := &redactor{headers: rr.Headers, query: rr.Query}
+	for _, h := range rr.Headers {
+		if strings.TrimSpace(h) == "" {
+			return nil, errors.New("headers must not be empty.")
+		}
+	}
+	for _, q := range rr.Query {
+		if q == "" {
+			return nil, errors.New("query parameters must not be empty.")
+		}
+	}
+
+	for _, expr := range rr.Paths {
+		expr = strings.TrimSpace(expr)
+		if !strings.HasPrefix(expr, "$") {
+			return nil, fmt.Errorf("%q must start with $.", expr)
+		}
+		keys, rest, err := parseJSONPath(expr[1:])
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid JSONPath: %v", expr, err)
+		}
+		if rest != "" || len(keys) == 0 {
+			return nil, fmt.Errorf("%q must be a path to a value, like $.user.email", expr)
+		}
+		rd.paths = append(rd.paths, redactionPath{expr: expr, keys: keys})
+	}
+
+	for _, p := range rr.Patterns {
+		re, err := regexp.Compile(p)
+		if err != nil {
+			return nil, fmt.Errorf("%q is not a valid regular expression: %v", p, err)
+		}
+		rd.patterns = append(rd.patterns, re)
+	}
+	return rd, nil
+}
+
+// getRedactionRules returns the redaction rules of the given bin, or nil if it has none of its own.
+func getRedactionRules(name string) (*RedactionRules, error) {
+	var rr RedactionRules
+	found, err := getBinSetting(name, redactionSetting, &rr)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &rr, nil
+}
+
+// redactorFor returns a redactor applying the config's redaction rules along with the given bin's.
+func redactorFor(name string) (*redactor, error) {
+	rules := config.Redaction
+	rr, err := getRedactionRules(name)
+	if err != nil {
+		return nil, err
+	}
+	if rr != nil {
+		rules.Headers = append(append([]string{}, rules.Headers...), rr.Headers...)
+		rules.Query = append(append([]string{}, rules.Query...), rr.Query...)
+		rules.Paths = append(append([]string{}, rules.Paths...), rr.Paths...)
+		rules.Patterns = append(append([]string{}, rules.Patterns...), rr.Patterns...)
+	}
+	return rules.compile()
+}
+
+// redact removes whatever the rules pick out from the given headers, in place, and body. It
+// returns the redacted body and where something was removed, like "headers.Authorization",
+// "headers.Referer?api_key", "body?password" (for form bodies) or "body.user.email".
+func (rd *redactor) redact(headers map[string]string, body []byte) ([]byte, []string) {
+	redacted := make([]string, 0)
+	mark := func(where string) {
+		for _, r := range redacted {
+			if r == where {
+				return
+			}
+		}
+		redacted = append(redacted, where)
+	}
+
+	for k, v := range headers {
+		where := "headers." + k
+		if rd.isRedactedHeader(k) {
+			headers[k] = redactedValue
+			mark(where)
+			continue
+		}
+		if i := strings.Index(v, "?"); i >= 0 {
+			v = v[:i+1] + rd.redactQuery(v[i+1:], where, mark)
+		}
+		headers[k] = rd.redactPatterns(v, where, mark)
+	}
+
+	if len(body) == 0 {
+		return body, redacted
+	}
+	if strings.HasPrefix(headers["Content-Type"], "application/x-www-form-urlencoded") {
+		body = []byte(rd.redactQuery(string(body), "body", mark))
+	}
+	body = rd.redactPaths(body, mark)
+	body = []byte(rd.redactPatterns(string(body), "body", mark))
+	return body, redacted
+}
+
+// isRedactedHeader returns true if the values of the named header are removed.
+func (rd *redactor) isRedactedHeader(name string) bool {
+	for _, h := range rd.headers {
+		if strings.EqualFold(strings.TrimSpace(h), name) {
+			return true
+		}
+	}
+	return false
+}
+
+// redactQuery removes the values of the redacted parameters from a query string, keeping the
+// order of its parameters and anything following a "#".
+func (rd *redactor) redactQuery(query, where string, mark func(string)) string {
+	if len(rd.query) == 0 {
+		return query
+	}
+
+	fragment := ""
+	if i := strings.Index(query, "#"); i >= 0 {
+		query, fragment = query[:i], query[i:]
+	}
+
+	params := strings.Split(query, "&")
+	for i, param := range params {
+		rawKey := strings.SplitN(param, "=", 2)[0]
+		key, err := url.QueryUnescape(rawKey)
+		if err != nil {
+			key = rawKey
+		}
+		for _, q := range rd.query {
+			if strings.EqualFold(q, key) {
+				params[i] = rawKey + "=" + redactedValue
+				mark(where + "?" + key)
+				break
+			}
+		}
+	}
+	return strings.Join(params, "&") + fragment
+}
+
+// redactPaths removes the values at the redacted paths from a JSON body. Bodies that aren't JSON
+// are returned as they are.
+func (rd *redactor) redactPaths(body []byte, mark func(string)) []byte {
+	if len(rd.paths) == 0 {
+		return body
+	}
+
+	var js interface{}
+	decoder := json.NewDecoder(strings.NewReader(string(body)))
+	// keep numbers as they were sent
+	decoder.UseNumber()
+	if err := decoder.Decode(&js); err != nil {
+		return body
+	}
+
+	found := false
+	for _, p := range rd.paths {
+		if redactAtPath(js, p.keys) {
+			found = true
+			mark("body" + p.expr[1:])
+		}
+	}
+	if !found {
+		return body
+	}
+
+	encoded, err := json.Marshal(js)
+	if err != nil {
+		log.Println("Error marshalling redacted body:", err)
+		return body
+	}
+	return encoded
+}
+
+// redactAtPath replaces the value at the given path of a JSON document, returning false if there
+// is no such value.
+func redactAtPath(js interface{}, path []string) bool {
+	parent, ok := valueAtPath(js, path[:len(path)-1])
+	if !ok {
+		return false
+	}
+
+	key := path[len(path)-1]
+	switch t := parent.(type) {
+	case map[string]interface{}:
+		if _, ok := t[key]; !ok {
+			return false
+		}
+		t[key] = redactedValue
+		return true
+	case []interface{}:
+		i, err := strconv.Atoi(key)
+		if err != nil || i < 0 || i >= len(t) {
+			return false
+		}
+		t[i] = redactedValue
+		return true
+	}
+	return false
+}
+
+// redactPatterns replaces the matches of the redacted patterns in s.
+func (rd *redactor) redactPatterns(s, where string, mark func(string)) string {
+	for _, re := range rd.patterns {
+		if re.MatchString(s) {
+			s = re.ReplaceAllLiteralString(s, redactedValue)
+			mark(where)
+		}
+	}
+	return s
+}
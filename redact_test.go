@@ -0,0 +1,73 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestRedactionRulesValidate(t *testing.T) {
+	assert.Equal(t, nil, RedactionRules{}.validate())
+	assert.Equal(t, nil, RedactionRules{Headers: []string{"Authorization"}, Paths: []string{"$.user.email", "$.cards[0]"}, Patterns: []string{`\d+`}}.validate())
+	assert.NotEqual(t, nil, RedactionRules{Headers: []string{" "}}.validate())
+	assert.NotEqual(t, nil, RedactionRules{Paths: []string{"user.email"}}.validate())
+	assert.NotEqual(t, nil, RedactionRules{Paths: []string{"$"}}.validate())
+	assert.NotEqual(t, nil, RedactionRules{Paths: []string{"$.a == 1"}}.validate())
+	assert.NotEqual(t, nil, RedactionRules{Patterns: []string{"("}}.validate())
+}
+
+func TestRedact(t *testing.T) {
+	rd, err := RedactionRules{
+		Headers:  []string{"authorization"},
+		Query:    []string{"api_key"},
+		Paths:    []string{"$.user.email", "$.cards[1]", "$.missing"},
+		Patterns: []string{`\d{3}-\d{2}-\d{4}`},
+	}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]string{
+		"Authorization": "Bearer s3cr3t",
+		"Referer":       "http://example.com/?a=1&api_key=s3cr3t#top",
+		"X-Ssn":         "123-45-6789",
+		"Content-Type":  "application/json",
+	}
+	body, redacted := rd.redact(headers, []byte(`{"user": {"email": "a@example.com", "id": 10.50}, "cards": ["1", "2"], "ssn": "123-45-6789"}`))
+
+	assert.Equal(t, redactedValue, headers["Authorization"])
+	assert.Equal(t, "http://example.com/?a=1&api_key=[REDACTED]#top", headers["Referer"])
+	assert.Equal(t, redactedValue, headers["X-Ssn"])
+	assert.Equal(t, "application/json", headers["Content-Type"])
+	assert.Equal(t, `{"cards":["1","[REDACTED]"],"ssn":"[REDACTED]","user":{"email":"[REDACTED]","id":10.50}}`, string(body))
+
+	expected := map[string]bool{
+		"headers.Authorization":   true,
+		"headers.Referer?api_key": true,
+		"headers.X-Ssn":           true,
+		"body.user.email":         true,
+		"body.cards[1]":           true,
+		"body":                    true,
+	}
+	assert.Equal(t, len(expected), len(redacted))
+	for _, where := range redacted {
+		assert.T(t, expected[where], where)
+	}
+}
+
+func TestRedactFormBody(t *testing.T) {
+	rd, err := RedactionRules{Query: []string{"password"}, Paths: []string{"$.password"}}.compile()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	headers := map[string]string{"Content-Type": "application/x-www-form-urlencoded"}
+	body, redacted := rd.redact(headers, []byte("user=a&password=hunter2"))
+	assert.Equal(t, "user=a&password=[REDACTED]", string(body))
+	assert.Equal(t, []string{"body?password"}, redacted)
+
+	// without the form content type, the body is left alone
+	body, redacted = rd.redact(map[string]string{}, []byte("user=a&password=hunter2"))
+	assert.Equal(t, "user=a&password=hunter2", string(body))
+	assert.Equal(t, 0, len(redacted))
+}
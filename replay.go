@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"sort"
+	"time"
+)
+
+// number of replays kept in the log of each bin
+const maxReplays = 100
+
+// ReplayRequest describes a stored request to re-issue and where to send it.
+type ReplayRequest struct {
+	ID  string `json:"id"`
+	URL string `json:"url"`
+	// headers added to, or replacing those of, the replayed request
+	Headers map[string]string `json:"headers,omitempty"`
+}
+
+// ReplayRecord is an entry in the replay log of a bin.
+type ReplayRecord struct {
+	Timestamp int64  `json:"timestamp"`
+	RequestID string `json:"requestId"`
+	URL       string `json:"url"`
+	// names of the headers given for the replay, whose values may be credentials and aren't kept
+	Headers []string `json:"headers,omitempty"`
+	Status  int      `json:"status,omitempty"`
+	Latency float64  `json:"latency"` // in milliseconds
+	Error   string   `json:"error,omitempty"`
+}
+
+// ReplayResult is the outcome of a replay, along with the upstream response if one was received.
+// The body of the response is left out if the config's OmitReplayResponseBodies is set.
+type ReplayResult struct {
+	Replay   ReplayRecord      `json:"replay"`
+	Response *UpstreamResponse `json:"response,omitempty"`
+}
+
+// validate checks that the replay names a request and an absolute http(s) URL.
+func (rr ReplayRequest) validate() error {
+	if rr.ID == "" {
+		return errors.New("id is required.")
+	}
+	return validateTargetURL(rr.URL)
+}
+
+// replaysKey returns the redis key of the list holding the replay log of the given bin.
+func replaysKey(name string) string {
+	return "replays:" + name
+}
+
+// replay re-issues gr to the URL given in rr and records the attempt in the replay log of the
+// given bin.
+func replay(name string, gr *GeobinRequest, rr ReplayRequest) ReplayResult {
+	result := ReplayResult{
+		Replay: ReplayRecord{
+			Timestamp: time.Now().UTC().Unix(),
+			RequestID: rr.ID,
+			URL:       rr.URL,
+		},
+	}
+	for k := range rr.Headers {
+		result.Replay.Headers = append(result.Replay.Headers, k)
+	}
+	sort.Strings(result.Replay.Headers)
+
+	start := time.Now()
+	resp, err := sendRequest(gr, rr.URL, rr.Headers)
+	result.Replay.Latency = float64(time.Since(start)) / float64(time.Millisecond)
+	if resp != nil {
+		result.Replay.Status = resp.Status
+		if config.OmitReplayResponseBodies {
+			resp.Body = ""
+		}
+		result.Response = resp
+	}
+	if err != nil {
+		result.Replay.Error = err.Error()
+	}
+
+	if err := logReplay(name, result.Replay); err != nil {
+		log.Println("Failure to log replay for", name, err)
+	}
+
+	return result
+}
+
+// logReplay adds rec to the front of the replay log of the given bin, dropping the oldest entries
+// once there are more than maxReplays.
+func logReplay(name string, rec ReplayRecord) error {
+	encoded, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+
+	key := replaysKey(name)
+	if res := client.LPush(key, string(encoded)); res.Err() != nil {
+		log.Println("Failure to LPUSH", key, res.Err())
+		return res.Err()
+	}
+	if res := client.LTrim(key, 0, maxReplays-1); res.Err() != nil {
+		log.Println("Failure to LTRIM", key, res.Err())
+		return res.Err()
+	}
+
+	return expireWithBin(name, key)
+}
+
+// getReplays returns the replay log of the given bin, most recent first.
+func getReplays(name string) ([]ReplayRecord, error) {
+	key := replaysKey(name)
+	res := client.LRange(key, 0, -1)
+	if res.Err() != nil {
+		log.Println("Failure to LRANGE", key, res.Err())
+		return nil, res.Err()
+	}
+
+	records := make([]ReplayRecord, 0, len(res.Val()))
+	for _, v := range res.Val() {
+		var rec ReplayRecord
+		if err := json.Unmarshal([]byte(v), &rec); err != nil {
+			log.Println("Error unmarshalling replay log:", err)
+			continue
+		}
+		records = append(records, rec)
+	}
+
+	return records, nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestReplayRequestValidate(t *testing.T) {
+	assert.Equal(t, nil, ReplayRequest{ID: "1-1", URL: "http://example.com/hook"}.validate())
+	assert.NotEqual(t, nil, ReplayRequest{URL: "http://example.com/hook"}.validate())
+	assert.NotEqual(t, nil, ReplayRequest{ID: "1-1", URL: "ftp://example.com"}.validate())
+	assert.NotEqual(t, nil, ReplayRequest{ID: "1-1", URL: "/relative"}.validate())
+}
+
+func TestParseRequestID(t *testing.T) {
+	id := newRequestID(1398551966)
+	ts, _, ok := parseRequestID(id)
+	assert.T(t, ok)
+	assert.Equal(t, int64(1398551966), ts)
+
+	for _, id := range []string{"", "1398551966", "a-1", "1-b", "1-2-3"} {
+		_, _, ok := parseRequestID(id)
+		assert.Equal(t, false, ok, id)
+	}
+}
+
+func TestReplay(t *testing.T) {
+	defer allowPrivateTargets()()
+	binId, err := createBin()
+	if err != nil {
+		t.Error("Could not create bin")
+	}
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "yes", r.Header.Get("X-Replayed"))
+		w.WriteHeader(http.StatusAccepted)
+		w.Write([]byte("accepted"))
+	}))
+	defer ts.Close()
+
+	gr := NewGeobinRequest(0, map[string]string{}, []byte(`{"lat": 10, "lng": -10}`))
+	gr.ID = newRequestID(0)
+	rr := ReplayRequest{ID: gr.ID, URL: ts.URL, Headers: map[string]string{"X-Replayed": "yes"}}
+
+	result := replay(binId, gr, rr)
+	assert.Equal(t, http.StatusAccepted, result.Replay.Status)
+	assert.Equal(t, "", result.Replay.Error)
+	assert.Equal(t, "accepted", result.Response.Body)
+	assert.Equal(t, []string{"X-Replayed"}, result.Replay.Headers)
+
+	// the upstream body can be left out by the config
+	config.OmitReplayResponseBodies = true
+	result = replay(binId, gr, rr)
+	config.OmitReplayResponseBodies = false
+	assert.Equal(t, "", result.Response.Body)
+
+	// failures are recorded too
+	result = replay(binId, gr, ReplayRequest{ID: gr.ID, URL: "http://127.0.0.1:1"})
+	assert.Equal(t, (*UpstreamResponse)(nil), result.Response)
+	assert.NotEqual(t, "", result.Replay.Error)
+
+	records, err := getReplays(binId)
+	if err != nil {
+		t.Error(err)
+	}
+	assert.Equal(t, 3, len(records))
+	assert.Equal(t, "http://127.0.0.1:1", records[0].URL)
+	assert.Equal(t, ts.URL, records[1].URL)
+	// override header values aren't kept, only their names
+	assert.Equal(t, []string{"X-Replayed"}, records[1].Headers)
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"sync"
+)
+
+// most requests of a backlog sent to a resuming socket. Together with a missed notice they fit in
+// the socket's outbox, so none of them are dropped however slowly the client reads.
+const maxResumeBacklog = outboxSize - 1
+
+// resumeSocket wraps a Socket that is catching up on the requests it missed. Messages written to
+// it before the backlog has been sent are held back until afterwards, and messages for requests
+// that were part of the backlog are dropped, so the client gets every request once and in order.
+type resumeSocket struct {
+	Socket
+
+	lk       sync.Mutex
+	caughtUp bool
+	pending  [][]byte
+	// IDs of the requests sent as part of the backlog, forgotten once a newer request is written
+	sent map[string]bool
+}
+
+// newResumeSocket wraps s, holding back anything written to it until catchUp is called.
+func newResumeSocket(s Socket) *resumeSocket {
+	return &resumeSocket{
+		Socket: s,
+		sent:   make(map[string]bool),
+	}
+}
+
+func (rs *resumeSocket) Write(payload []byte) {
+	rs.lk.Lock()
+	defer rs.lk.Unlock()
+
+	if !rs.caughtUp {
+		rs.pending = append(rs.pending, payload)
+		return
+	}
+	rs.write(payload)
+}
+
+// catchUp sends the given backlog of requests, followed by any messages that were held back while
+// it was being read. Messages written afterwards are sent as they come in. Only the last
+// maxResumeBacklog requests are sent, after a missed notice counting the ones left out, which the
+// client can read from the bin's history.
+func (rs *resumeSocket) catchUp(backlog []*GeobinRequest) {
+	rs.lk.Lock()
+	defer rs.lk.Unlock()
+
+	if skipped := len(backlog) - maxResumeBacklog; skipped > 0 {
+		notice, err := json.Marshal(SocketNotice{Type: "missed", Count: skipped})
+		if err != nil {
+			log.Println("Error marshalling socket notice:", err)
+		} else {
+			rs.Socket.Write(notice)
+		}
+		backlog = backlog[skipped:]
+	}
+
+	for _, gr := range backlog {
+		payload, err := json.Marshal(gr)
+		if err != nil {
+			log.Println("Error marshalling request history:", err)
+			continue
+		}
+		rs.Socket.Write(payload)
+		rs.sent[gr.ID] = true
+	}
+
+	for _, payload := range rs.pending {
+		rs.write(payload)
+	}
+	rs.pending = nil
+	rs.caughtUp = true
+}
+
+// write sends payload on to the wrapped socket unless it was already sent as part of the backlog.
+func (rs *resumeSocket) write(payload []byte) {
+	id := payloadID(payload)
+	if id != "" && rs.sent[id] {
+		return
+	}
+	if id != "" && rs.caughtUp {
+		// requests are published in order, so none of the backlog can follow a newer one
+		rs.sent = nil
+	}
+	rs.Socket.Write(payload)
+}
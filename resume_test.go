@@ -0,0 +1,54 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestResumeSocket(t *testing.T) {
+	ms := &MockSocket{name: "test_socket"}
+	rs := newResumeSocket(ms)
+
+	// held back until the backlog is sent
+	rs.Write([]byte(`{"id":"2-2"}`))
+	rs.Write([]byte(`{"id":"3-3"}`))
+	assert.Equal(t, false, ms.getDidWrite())
+
+	rs.catchUp([]*GeobinRequest{
+		{ID: "1-1", Timestamp: 1, Headers: map[string]string{}},
+		{ID: "2-2", Timestamp: 2, Headers: map[string]string{}},
+	})
+
+	// sent straight through once caught up, except for requests in the backlog
+	rs.Write([]byte(`{"id":"2-2"}`))
+	rs.Write([]byte(`{"id":"4-4"}`))
+
+	assert.Equal(t, []string{
+		`{"id":"1-1","timestamp":1,"headers":{},"body":""}`,
+		`{"id":"2-2","timestamp":2,"headers":{},"body":""}`,
+		`{"id":"3-3"}`,
+		`{"id":"4-4"}`,
+	}, ms.getWritten())
+	assert.Equal(t, "test_socket", rs.GetName())
+	// the backlog is forgotten once a newer request has been sent
+	assert.Equal(t, 0, len(rs.sent))
+}
+
+func TestResumeSocketLongBacklog(t *testing.T) {
+	ms := &MockSocket{name: "test_socket"}
+	rs := newResumeSocket(ms)
+
+	backlog := make([]*GeobinRequest, maxResumeBacklog+10)
+	for i := range backlog {
+		backlog[i] = &GeobinRequest{ID: fmt.Sprintf("%d-%d", i+1, i+1), Timestamp: int64(i + 1), Headers: map[string]string{}}
+	}
+	rs.catchUp(backlog)
+
+	// the oldest requests are left out, and the client is told how many
+	written := ms.getWritten()
+	assert.Equal(t, maxResumeBacklog+1, len(written))
+	assert.Equal(t, `{"type":"missed","count":10}`, written[0])
+	assert.Equal(t, `{"id":"11-11","timestamp":11,"headers":{},"body":""}`, written[1])
+}
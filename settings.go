@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+
+	redis "github.com/vmihailenco/redis/v2"
+)
+
+// settingsKey returns the redis key of the hash holding the settings for the given bin.
+// Each feature stores its settings as JSON under its own field of the hash.
+func settingsKey(name string) string {
+	return "settings:" + name
+}
+
+// binDataKeys returns the redis keys of everything stored alongside the given bin, which expire
+// along with it.
+func binDataKeys(name string) []string {
+	return []string{settingsKey(name), fenceStateKey(name), replaysKey(name), linksKey(name), metaKey(name)}
+}
+
+// getBinSetting reads the given settings field of a bin into v. It returns false if the field
+// has never been set.
+func getBinSetting(name, field string, v interface{}) (bool, error) {
+	res, err := client.HGet(settingsKey(name), field).Result()
+	if err == redis.Nil {
+		return false, nil
+	}
+	if err != nil {
+		log.Println("Failure to HGET", field, "for", name, err)
+		return false, err
+	}
+
+	if err := json.Unmarshal([]byte(res), v); err != nil {
+		log.Println("Error unmarshalling", field, "for", name, err)
+		return false, err
+	}
+
+	return true, nil
+}
+
+// setBinSetting stores v as the given settings field of a bin. The settings expire along with
+// the bin.
+func setBinSetting(name, field string, v interface{}) error {
+	encoded, err := json.Marshal(v)
+	if err != nil {
+		log.Println("Error marshalling", field, "for", name, err)
+		return err
+	}
+
+	if res := client.HSet(settingsKey(name), field, string(encoded)); res.Err() != nil {
+		log.Println("Failure to HSET", field, "for", name, res.Err())
+		return res.Err()
+	}
+
+	return expireWithBin(name, settingsKey(name))
+}
+
+// expireWithBin sets the expiration of key to match that of the given bin, so that anything
+// stored alongside a bin goes away with it.
+func expireWithBin(name, key string) error {
+	ttl, err := client.TTL(name).Result()
+	if err != nil {
+		log.Println("Failure to get TTL for", name, err)
+		return err
+	}
+
+	// the bin doesn't expire
+	if ttl <= 0 {
+		if res := client.Persist(key); res.Err() != nil {
+			log.Println("Failure to PERSIST", key, res.Err())
+			return res.Err()
+		}
+		return nil
+	}
+
+	if res := client.Expire(key, ttl); res.Err() != nil {
+		log.Println("Failure to set EXPIRE for", key, res.Err())
+		return res.Err()
+	}
+	return nil
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"hash"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// name of the settings field holding a bin's signature settings
+const signatureSetting = "signature"
+
+// The ways of signing a request we know how to check.
+const (
+	// GitHub style, an X-Hub-Signature-256 header of "sha256=" and the hex HMAC-SHA256 of the body
+	githubScheme = "github"
+	// Stripe style, a Stripe-Signature header of "t={timestamp},v1={signature}" where the signature
+	// is the hex HMAC-SHA256 of the timestamp, a dot and the body
+	stripeScheme = "stripe"
+	// the HMAC-SHA256 of the body in the given header
+	hmacSHA256Scheme = "hmac-sha256"
+	// the HMAC-SHA1 of the body in the given header
+	hmacSHA1Scheme = "hmac-sha1"
+)
+
+// how far a Stripe style timestamp may be from now, in seconds, by default
+const defaultSignatureTolerance = 300
+
+// SignatureSettings describes how the requests sent to a bin are signed.
+type SignatureSettings struct {
+	Scheme string `json:"scheme"`
+	// the secret shared with whoever signs the requests
+	Secret string `json:"secret"`
+	// the header holding the signature, for the hmac schemes
+	Header string `json:"header,omitempty"`
+	// "hex" (the default) or "base64", for the hmac schemes
+	Encoding string `json:"encoding,omitempty"`
+	// seconds a Stripe style timestamp may be from now, defaults to 300
+	Tolerance int `json:"tolerance,omitempty"`
+	// answer requests that aren't correctly signed with a 401, instead of only recording it
+	Reject bool `json:"reject,omitempty"`
+}
+
+// what the secret of a bin's signature settings is shown as, so that it can't be read back
+const maskedSecret = "********"
+
+// SignatureResult records whether a request was correctly signed, and why not if it wasn't.
+type SignatureResult struct {
+	Valid  bool   `json:"valid"`
+	Reason string `json:"reason,omitempty"`
+}
+
+// validate checks the scheme, secret and the options of the scheme.
+func (ss SignatureSettings) validate() error {
+	switch ss.Scheme {
+	case githubScheme, stripeScheme:
+	case hmacSHA256Scheme, hmacSHA1Scheme:
+		if ss.Header == "" {
+			return fmt.Errorf("The %s scheme needs a header.", ss.Scheme)
+		}
+		if ss.Encoding != "" && ss.Encoding != "hex" && ss.Encoding != "base64" {
+			return errors.New("encoding must be hex or base64.")
+		}
+	default:
+		return fmt.Errorf("scheme must be one of %s, %s, %s or %s.", githubScheme, stripeScheme, hmacSHA256Scheme, hmacSHA1Scheme)
+	}
+
+	if ss.Secret == "" {
+		return errors.New("secret must not be empty.")
+	}
+	if ss.Tolerance < 0 {
+		return errors.New("tolerance must not be negative.")
+	}
+	return nil
+}
+
+// getSignatureSettings returns the signature settings of the given bin, or nil if its requests
+// aren't signed.
+func getSignatureSettings(name string) (*SignatureSettings, error) {
+	var ss SignatureSettings
+	found, err := getBinSetting(name, signatureSetting, &ss)
+	if err != nil || !found {
+		return nil, err
+	}
+	return &ss, nil
+}
+
+// verify checks the signature of a request with the given headers and body, received at `now`.
+func (ss SignatureSettings) verify(header http.Header, body []byte, now time.Time) *SignatureResult {
+	var reason string
+	switch ss.Scheme {
+	case githubScheme:
+		reason = ss.verifyGithub(header, body)
+	case stripeScheme:
+		reason = ss.verifyStripe(header, body, now)
+	default:
+		reason = ss.verifyHMAC(header, body)
+	}
+
+	return &SignatureResult{Valid: reason == "", Reason: reason}
+}
+
+// verifyGithub returns why a GitHub style signature is wrong, or "" if it's right.
+func (ss SignatureSettings) verifyGithub(header http.Header, body []byte) string {
+	sig := header.Get("X-Hub-Signature-256")
+	if sig == "" {
+		return "missing X-Hub-Signature-256 header"
+	}
+	if !strings.HasPrefix(sig, "sha256=") {
+		return "X-Hub-Signature-256 must start with sha256="
+	}
+
+	expected := hex.EncodeToString(computeHMAC(sha256.New, ss.Secret, body))
+	if !hmac.Equal([]byte(strings.TrimPrefix(sig, "sha256=")), []byte(expected)) {
+		return "signature does not match"
+	}
+	return ""
+}
+
+// verifyStripe returns why a Stripe style signature is wrong, or "" if it's right.
+func (ss SignatureSettings) verifyStripe(header http.Header, body []byte, now time.Time) string {
+	sig := header.Get("Stripe-Signature")
+	if sig == "" {
+		return "missing Stripe-Signature header"
+	}
+
+	var timestamp string
+	var signatures []string
+	for _, part := range strings.Split(sig, ",") {
+		kv := strings.SplitN(strings.TrimSpace(part), "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		switch kv[0] {
+		case "t":
+			timestamp = kv[1]
+		case "v1":
+			signatures = append(signatures, kv[1])
+		}
+	}
+
+	ts, err := strconv.ParseInt(timestamp, 10, 64)
+	if err != nil {
+		return "missing or invalid timestamp"
+	}
+	if len(signatures) == 0 {
+		return "missing v1 signature"
+	}
+
+	tolerance := int64(ss.Tolerance)
+	if tolerance == 0 {
+		tolerance = defaultSignatureTolerance
+	}
+	if d := now.Unix() - ts; d > tolerance || d < -tolerance {
+		return "timestamp is outside of the tolerance"
+	}
+
+	expected := hex.EncodeToString(computeHMAC(sha256.New, ss.Secret, []byte(timestamp+"."+string(body))))
+	for _, s := range signatures {
+		if hmac.Equal([]byte(s), []byte(expected)) {
+			return ""
+		}
+	}
+	return "signature does not match"
+}
+
+// verifyHMAC returns why a plain HMAC signature is wrong, or "" if it's right. A "sha256=" or
+// "sha1=" prefix is ignored.
+func (ss SignatureSettings) verifyHMAC(header http.Header, body []byte) string {
+	sig := header.Get(ss.Header)
+	if sig == "" {
+		return "missing " + http.CanonicalHeaderKey(ss.Header) + " header"
+	}
+
+	h := sha256.New
+	if ss.Scheme == hmacSHA1Scheme {
+		h = sha1.New
+	}
+	mac := computeHMAC(h, ss.Secret, body)
+
+	var expected string
+	if ss.Encoding == "base64" {
+		expected = base64.StdEncoding.EncodeToString(mac)
+	} else {
+		expected = hex.EncodeToString(mac)
+		sig = strings.ToLower(sig)
+	}
+
+	for _, prefix := range []string{"sha256=", "sha1="} {
+		sig = strings.TrimPrefix(sig, prefix)
+	}
+	if !hmac.Equal([]byte(sig), []byte(expected)) {
+		return "signature does not match"
+	}
+	return ""
+}
+
+// computeHMAC returns the HMAC of data with the given hash and secret.
+func computeHMAC(h func() hash.Hash, secret string, data []byte) []byte {
+	mac := hmac.New(h, []byte(secret))
+	mac.Write(data)
+	return mac.Sum(nil)
+}
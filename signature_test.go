@@ -0,0 +1,80 @@
+package main
+
+import (
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSignatureSettingsValidate(t *testing.T) {
+	assert.Equal(t, nil, SignatureSettings{Scheme: githubScheme, Secret: "s"}.validate())
+	assert.Equal(t, nil, SignatureSettings{Scheme: hmacSHA1Scheme, Secret: "s", Header: "X-Sig", Encoding: "base64"}.validate())
+	assert.NotEqual(t, nil, SignatureSettings{Scheme: "md5", Secret: "s"}.validate())
+	assert.NotEqual(t, nil, SignatureSettings{Scheme: githubScheme}.validate())
+	assert.NotEqual(t, nil, SignatureSettings{Scheme: hmacSHA256Scheme, Secret: "s"}.validate())
+	assert.NotEqual(t, nil, SignatureSettings{Scheme: hmacSHA256Scheme, Secret: "s", Header: "X-Sig", Encoding: "hex64"}.validate())
+	assert.NotEqual(t, nil, SignatureSettings{Scheme: stripeScheme, Secret: "s", Tolerance: -1}.validate())
+}
+
+func TestVerifyGithub(t *testing.T) {
+	ss := SignatureSettings{Scheme: githubScheme, Secret: "s3cr3t"}
+	body := []byte(`{"lat": 10, "lng": -10}`)
+	now := time.Now()
+
+	header := http.Header{}
+	assert.Equal(t, &SignatureResult{Valid: false, Reason: "missing X-Hub-Signature-256 header"}, ss.verify(header, body, now))
+
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(computeHMAC(sha256.New, "s3cr3t", body)))
+	assert.Equal(t, &SignatureResult{Valid: true}, ss.verify(header, body, now))
+
+	header.Set("X-Hub-Signature-256", "sha256="+hex.EncodeToString(computeHMAC(sha256.New, "wrong", body)))
+	assert.Equal(t, &SignatureResult{Valid: false, Reason: "signature does not match"}, ss.verify(header, body, now))
+}
+
+func TestVerifyStripe(t *testing.T) {
+	ss := SignatureSettings{Scheme: stripeScheme, Secret: "whsec"}
+	body := []byte(`{"id": "evt_1"}`)
+	now := time.Unix(1400000000, 0)
+	sign := func(ts int64) string {
+		return hex.EncodeToString(computeHMAC(sha256.New, "whsec", []byte(fmt.Sprintf("%d.%s", ts, body))))
+	}
+
+	header := http.Header{}
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s,v1=%s", now.Unix(), "bogus", sign(now.Unix())))
+	assert.Equal(t, &SignatureResult{Valid: true}, ss.verify(header, body, now))
+
+	// too old
+	old := now.Unix() - defaultSignatureTolerance - 1
+	header.Set("Stripe-Signature", fmt.Sprintf("t=%d,v1=%s", old, sign(old)))
+	assert.Equal(t, &SignatureResult{Valid: false, Reason: "timestamp is outside of the tolerance"}, ss.verify(header, body, now))
+
+	// unless the tolerance allows it
+	ss.Tolerance = defaultSignatureTolerance + 1
+	assert.Equal(t, &SignatureResult{Valid: true}, ss.verify(header, body, now))
+
+	header.Set("Stripe-Signature", fmt.Sprintf("v1=%s", sign(now.Unix())))
+	assert.Equal(t, &SignatureResult{Valid: false, Reason: "missing or invalid timestamp"}, ss.verify(header, body, now))
+}
+
+func TestVerifyHMAC(t *testing.T) {
+	body := []byte("hello")
+	now := time.Now()
+
+	ss := SignatureSettings{Scheme: hmacSHA1Scheme, Secret: "key", Header: "X-Signature"}
+	header := http.Header{}
+	assert.Equal(t, &SignatureResult{Valid: false, Reason: "missing X-Signature header"}, ss.verify(header, body, now))
+	header.Set("X-Signature", "sha1="+hex.EncodeToString(computeHMAC(sha1.New, "key", body)))
+	assert.Equal(t, &SignatureResult{Valid: true}, ss.verify(header, body, now))
+
+	ss = SignatureSettings{Scheme: hmacSHA256Scheme, Secret: "key", Header: "X-Shopify-Hmac-Sha256", Encoding: "base64"}
+	header.Set("X-Shopify-Hmac-Sha256", base64.StdEncoding.EncodeToString(computeHMAC(sha256.New, "key", body)))
+	assert.Equal(t, &SignatureResult{Valid: true}, ss.verify(header, body, now))
+	assert.Equal(t, &SignatureResult{Valid: false, Reason: "signature does not match"}, ss.verify(header, []byte("tampered"), now))
+}
@@ -37,8 +37,8 @@ type s struct {
 	// the websocket connection
 	ws *websocket.Conn
 
-	// buffered channel of outbound messages
-	send chan []byte
+	// buffer of outbound messages
+	out *outbox
 
 	shutdown  chan bool
 	closed    bool
@@ -57,7 +57,14 @@ type s struct {
 // `oc` here is the func that's called when the socket is just about to be closed. The call is made from a
 // separate routine.
 // If you do not care about these callbacks, pass nil instead.
+// How the socket handles a client that can't keep up is read from the request, see backpressureFromRequest.
 func NewSocket(name string, w http.ResponseWriter, r *http.Request, or func(int, []byte), oc func(string)) (Socket, error) {
+	bp, err := backpressureFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+
 	ws, err := websocket.Upgrade(w, r, nil, 1024, 1024)
 	if _, ok := err.(websocket.HandshakeError); ok {
 		http.Error(w, "Not a websocket handshake", http.StatusBadRequest)
@@ -67,7 +74,7 @@ func NewSocket(name string, w http.ResponseWriter, r *http.Request, or func(int,
 		return nil, err
 	}
 
-	return socketSetup(name, ws, or, oc), nil
+	return socketSetup(name, ws, bp, or, oc), nil
 }
 
 // NewClient creates a client web socket connection to the host running at the provided URL.
@@ -97,10 +104,10 @@ func NewClient(name string, socketURL string, or func(int, []byte), oc func(stri
 		return nil, err
 	}
 
-	return socketSetup(name, ws, or, oc), nil
+	return socketSetup(name, ws, defaultBackpressure, or, oc), nil
 }
 
-func socketSetup(name string, ws *websocket.Conn, or func(int, []byte), oc func(string)) Socket {
+func socketSetup(name string, ws *websocket.Conn, bp Backpressure, or func(int, []byte), oc func(string)) Socket {
 	if or == nil {
 		or = func(int, []byte) {}
 	}
@@ -112,13 +119,16 @@ func socketSetup(name string, ws *websocket.Conn, or func(int, []byte), oc func(
 	s := &s{
 		name:      name,
 		ws:        ws,
-		send:      make(chan []byte, 256),
 		shutdown:  make(chan bool),
 		closed:    false,
 		closeLock: &sync.Mutex{},
 		onRead:    or,
 		onClose:   oc,
 	}
+	s.out = newOutbox(bp, func() {
+		log.Println("["+s.name+"]", "Closing socket that missed", bp.MaxMissed, "messages")
+		s.Close()
+	})
 
 	go s.writePump()
 	go s.readPump()
@@ -126,17 +136,21 @@ func socketSetup(name string, ws *websocket.Conn, or func(int, []byte), oc func(
 }
 
 func (s *s) Write(payload []byte) {
-	s.send <- payload
+	s.out.push(payload)
 }
 
 func (s *s) Close() {
 	s.closeLock.Lock()
 	if s.closed {
+		s.closeLock.Unlock()
 		return
 	}
 	s.closed = true
 	s.closeLock.Unlock()
 
+	if dropped := s.out.droppedCount(); dropped > 0 {
+		debugLog("["+s.name+"]", "Dropped", dropped, "messages")
+	}
 	s.onClose(s.name)
 	s.ws.Close()
 }
@@ -172,7 +186,7 @@ func (s *s) writePump() {
 		select {
 		case <-s.shutdown:
 			return
-		case message := <-s.send:
+		case message := <-s.out.ch:
 			if err := s.write(websocket.TextMessage, message); err != nil {
 				log.Println("["+s.name+"]", "Error during socket write:", err)
 				s.Close()
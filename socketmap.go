@@ -1,16 +1,29 @@
 package main
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"log"
+	"sort"
 	"sync"
 )
 
 type SocketMap interface {
-	Add(binName, socketUUID string, s Socket)
+	// Add subscribes a socket to a bin, only sending it the requests matching f if f isn't nil.
+	Add(binName, socketUUID string, s Socket, f *StreamFilter)
 	Get(binName, socketUUID string) (Socket, bool)
 	Delete(binName, socketUUID string) error
 	Send(binName string, payload []byte) error
+	// SetFilter limits the payloads sent to a socket to the requests matching f, or removes the
+	// socket's filter if f is nil.
+	SetFilter(binName, socketUUID string, f *StreamFilter) error
+	// Bins returns the names of the bins a socket is subscribed to.
+	Bins(socketUUID string) []string
+	// DeleteAll removes a socket from every bin it is subscribed to.
+	DeleteAll(socketUUID string) error
+	// DeleteBin removes every socket subscribed to a bin, returning them.
+	DeleteBin(binName string) []Socket
 }
 
 type UnSub interface {
@@ -26,21 +39,37 @@ func NewSocketMap(unsubber UnSub) SocketMap {
 type sm struct {
 	lk       sync.Mutex
 	unsubber UnSub
-	smap     map[string]map[string]Socket
+	smap     map[string]map[string]*subscription
+	// the bins each socket is subscribed to
+	bins map[string]map[string]bool
 }
 
-func (sm *sm) Add(binName, socketUUID string, s Socket) {
+// subscription is a socket listening to a bin, along with the filter for what it is sent.
+type subscription struct {
+	socket Socket
+	filter *StreamFilter
+}
+
+func (sm *sm) Add(binName, socketUUID string, s Socket, f *StreamFilter) {
 	sm.lk.Lock()
 	defer sm.lk.Unlock()
 	if sm.smap == nil {
-		sm.smap = make(map[string]map[string]Socket)
+		sm.smap = make(map[string]map[string]*subscription)
 	}
 
 	if _, ok := sm.smap[binName]; !ok {
-		sm.smap[binName] = make(map[string]Socket)
+		sm.smap[binName] = make(map[string]*subscription)
 	}
 
-	sm.smap[binName][socketUUID] = s
+	sm.smap[binName][socketUUID] = &subscription{socket: s, filter: f}
+
+	if sm.bins == nil {
+		sm.bins = make(map[string]map[string]bool)
+	}
+	if _, ok := sm.bins[socketUUID]; !ok {
+		sm.bins[socketUUID] = make(map[string]bool)
+	}
+	sm.bins[socketUUID][binName] = true
 }
 
 func (sm *sm) Get(binName, socketUUID string) (Socket, bool) {
@@ -54,12 +83,61 @@ func (sm *sm) Get(binName, socketUUID string) (Socket, bool) {
 		return nil, false
 	}
 
-	return sm.smap[binName][socketUUID], true
+	sub, ok := sm.smap[binName][socketUUID]
+	if !ok {
+		return nil, false
+	}
+	return sub.socket, true
 }
 
 func (sm *sm) Delete(binName, socketUUID string) error {
 	sm.lk.Lock()
 	defer sm.lk.Unlock()
+	return sm.delete(binName, socketUUID)
+}
+
+func (sm *sm) DeleteAll(socketUUID string) error {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	var failed error
+	for binName := range sm.bins[socketUUID] {
+		if err := sm.delete(binName, socketUUID); err != nil {
+			failed = err
+		}
+	}
+	return failed
+}
+
+func (sm *sm) DeleteBin(binName string) []Socket {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	sockets := make([]Socket, 0, len(sm.smap[binName]))
+	for socketUUID, sub := range sm.smap[binName] {
+		if err := sm.delete(binName, socketUUID); err != nil {
+			log.Println(err)
+		}
+		sockets = append(sockets, sub.socket)
+	}
+	return sockets
+}
+
+func (sm *sm) Bins(socketUUID string) []string {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	bins := make([]string, 0, len(sm.bins[socketUUID]))
+	for binName := range sm.bins[socketUUID] {
+		bins = append(bins, binName)
+	}
+	sort.Strings(bins)
+	return bins
+}
+
+// delete removes a socket from a bin, unsubscribing from the bin if it was the last one. The
+// caller must hold the lock.
+func (sm *sm) delete(binName, socketUUID string) error {
 	if sm.smap == nil {
 		return errors.New("There are no known sockets.")
 	}
@@ -75,6 +153,11 @@ func (sm *sm) Delete(binName, socketUUID string) error {
 	}
 
 	delete(sockets, socketUUID)
+	delete(sm.bins[socketUUID], binName)
+	if len(sm.bins[socketUUID]) == 0 {
+		delete(sm.bins, socketUUID)
+	}
+
 	if len(sockets) == 0 {
 		delete(sm.smap, binName)
 
@@ -101,10 +184,37 @@ func (sm *sm) Send(binName string, payload []byte) error {
 		return errors.New(fmt.Sprint("Got message for unknown channel:", binName))
 	}
 
-	for _, s := range sockets {
-		go func(s Socket, p []byte) {
-			s.Write(p)
-		}(s, payload)
+	// the payload is only decoded if a socket has a filter to check it against, control messages
+	// aren't requests and so are always sent
+	var gr *GeobinRequest
+	var control bool
+	for _, sub := range sockets {
+		if sub.filter != nil {
+			if gr == nil {
+				gr = &GeobinRequest{}
+				json.Unmarshal(payload, gr)
+				control = payloadType(payload) != ""
+			}
+			if !control && !sub.filter.matches(gr) {
+				continue
+			}
+		}
+
+		// writes never block, a socket that can't keep up drops messages instead
+		sub.socket.Write(payload)
+	}
+	return nil
+}
+
+func (sm *sm) SetFilter(binName, socketUUID string, f *StreamFilter) error {
+	sm.lk.Lock()
+	defer sm.lk.Unlock()
+
+	sub, ok := sm.smap[binName][socketUUID]
+	if !ok {
+		return errors.New("No matching socket for that bin name and uuid.")
 	}
+
+	sub.filter = f
 	return nil
 }
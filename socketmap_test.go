@@ -18,12 +18,20 @@ type MockSocket struct {
 	lk       sync.Mutex
 	name     string
 	didWrite bool
+	written  []string
 }
 
 func (ms *MockSocket) Write(payload []byte) {
 	ms.lk.Lock()
 	defer ms.lk.Unlock()
 	ms.didWrite = true
+	ms.written = append(ms.written, string(payload))
+}
+
+func (ms *MockSocket) getWritten() []string {
+	ms.lk.Lock()
+	defer ms.lk.Unlock()
+	return ms.written
 }
 
 func (ms *MockSocket) getDidWrite() bool {
@@ -46,7 +54,7 @@ func TestNewSocketMap(t *testing.T) {
 func TestAddAndGet(t *testing.T) {
 	sm := NewSocketMap(getUnsubFunc(t))
 	ms := &MockSocket{name: "mock_socket"}
-	sm.Add("bin_name", "socket_uuid", ms)
+	sm.Add("bin_name", "socket_uuid", ms, nil)
 	sck, ok := sm.Get("bin_name", "socket_uuid")
 	assert.Equal(t, true, ok)
 	assert.Equal(t, "mock_socket", sck.GetName())
@@ -65,8 +73,8 @@ func TestDelete(t *testing.T) {
 	assert.NotEqual(t, nil, err)
 	ms1 := &MockSocket{name: "mock_socket1"}
 	ms2 := &MockSocket{name: "mock_socket2"}
-	sm.Add("bin_name", "socket_uuid1", ms1)
-	sm.Add("bin_name", "socket_uuid2", ms2)
+	sm.Add("bin_name", "socket_uuid1", ms1, nil)
+	sm.Add("bin_name", "socket_uuid2", ms2, nil)
 
 	err = sm.Delete("bin_name", "unknown_uuid")
 	assert.NotEqual(t, nil, err)
@@ -80,13 +88,61 @@ func TestDelete(t *testing.T) {
 	assert.Equal(t, true, didUnsub)
 }
 
+func TestManyBinsOneSocket(t *testing.T) {
+	var unsubbed []string
+	unsubf := func(channels ...string) error {
+		unsubbed = append(unsubbed, channels...)
+		return nil
+	}
+
+	sm := NewSocketMap(unsubFunc(unsubf))
+	ms := &MockSocket{name: "mock_socket"}
+	other := &MockSocket{name: "other_socket"}
+	sm.Add("bin_a", "socket_uuid", ms, nil)
+	sm.Add("bin_b", "socket_uuid", ms, nil)
+	sm.Add("bin_b", "other_uuid", other, nil)
+	assert.Equal(t, []string{"bin_a", "bin_b"}, sm.Bins("socket_uuid"))
+	assert.Equal(t, []string{"bin_b"}, sm.Bins("other_uuid"))
+
+	// only bins left without sockets are unsubscribed from
+	assert.Equal(t, nil, sm.DeleteAll("socket_uuid"))
+	assert.Equal(t, []string{"bin_a"}, unsubbed)
+	assert.Equal(t, []string{}, sm.Bins("socket_uuid"))
+	_, ok := sm.Get("bin_b", "socket_uuid")
+	assert.Equal(t, false, ok)
+	_, ok = sm.Get("bin_b", "other_uuid")
+	assert.Equal(t, true, ok)
+}
+
+func TestDeleteBin(t *testing.T) {
+	var unsubbed []string
+	unsubf := func(channels ...string) error {
+		unsubbed = append(unsubbed, channels...)
+		return nil
+	}
+
+	sm := NewSocketMap(unsubFunc(unsubf))
+	assert.Equal(t, 0, len(sm.DeleteBin("bin_a")))
+
+	ms1 := &MockSocket{name: "mock_socket1"}
+	ms2 := &MockSocket{name: "mock_socket2"}
+	sm.Add("bin_a", "socket_uuid1", ms1, nil)
+	sm.Add("bin_a", "socket_uuid2", ms2, nil)
+	sm.Add("bin_b", "socket_uuid2", ms2, nil)
+
+	assert.Equal(t, 2, len(sm.DeleteBin("bin_a")))
+	assert.Equal(t, []string{"bin_a"}, unsubbed)
+	assert.Equal(t, []string{}, sm.Bins("socket_uuid1"))
+	assert.Equal(t, []string{"bin_b"}, sm.Bins("socket_uuid2"))
+}
+
 func TestSend(t *testing.T) {
 	sm := NewSocketMap(getUnsubFunc(t))
 	err := sm.Send("bin_name", []byte("a message"))
 	assert.NotEqual(t, nil, err)
 	ms := &MockSocket{name: "mock_socket"}
 
-	sm.Add("bin_name", "socket_uuid", ms)
+	sm.Add("bin_name", "socket_uuid", ms, nil)
 	err = sm.Send("unknown_bin_name", []byte("a message"))
 	assert.NotEqual(t, nil, err)
 	err = sm.Send("bin_name", []byte("a message"))
@@ -96,6 +152,41 @@ func TestSend(t *testing.T) {
 	assert.Equal(t, true, ms.getDidWrite())
 }
 
+func TestSendWithFilter(t *testing.T) {
+	sm := NewSocketMap(getUnsubFunc(t))
+	all := &MockSocket{name: "all"}
+	filtered := &MockSocket{name: "filtered"}
+	added := &MockSocket{name: "added"}
+	sm.Add("bin_name", "all_uuid", all, nil)
+	sm.Add("bin_name", "filtered_uuid", filtered, nil)
+
+	f, err := parseStreamFilter([]byte(`{"hasGeo": true}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, nil, sm.SetFilter("bin_name", "filtered_uuid", f))
+	assert.NotEqual(t, nil, sm.SetFilter("bin_name", "unknown_uuid", f))
+	// a socket can be filtered from the moment it is added
+	sm.Add("bin_name", "added_uuid", added, f)
+
+	err = sm.Send("bin_name", []byte(`{"body": "{}"}`))
+	assert.Equal(t, nil, err)
+	time.Sleep(25 * time.Millisecond)
+	assert.Equal(t, true, all.getDidWrite())
+	assert.Equal(t, false, filtered.getDidWrite())
+	assert.Equal(t, false, added.getDidWrite())
+
+	// control messages get through any filter
+	err = sm.Send("bin_name", []byte(`{"type": "presence", "event": "join", "viewers": 2}`))
+	assert.Equal(t, nil, err)
+	assert.Equal(t, []string{`{"type": "presence", "event": "join", "viewers": 2}`}, filtered.getWritten())
+
+	// removing the filter sends everything again
+	assert.Equal(t, nil, sm.SetFilter("bin_name", "filtered_uuid", nil))
+	err = sm.Send("bin_name", []byte(`{"body": "{}"}`))
+	assert.Equal(t, nil, err)
+	time.Sleep(25 * time.Millisecond)
+	assert.Equal(t, 2, len(filtered.getWritten()))
+}
+
 func getUnsubFunc(t *testing.T) unsubFunc {
 	us := func(channels ...string) error {
 		t.Error("Unexpected call to unsubscibe!")
@@ -0,0 +1,166 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Send a comment to keep the connection open through proxies with this period.
+const sseHeartbeat = 15 * time.Second
+
+// sseSocket is a Socket that streams messages to a client as Server-Sent Events. Unlike a
+// websocket, it can only be written to while its handler is running, so the handler must call
+// Serve, which blocks until the socket is closed.
+type sseSocket struct {
+	// a string associated with the socket
+	name string
+
+	w       http.ResponseWriter
+	flusher http.Flusher
+
+	// buffer of outbound messages
+	out *outbox
+
+	// closed when the client goes away
+	gone <-chan struct{}
+
+	// closed when the socket is closed
+	done      chan bool
+	closed    bool
+	closeLock *sync.Mutex
+
+	onClose func(name string)
+}
+
+// NewSSESocket starts an event stream in response to a client request. `name` here is just an
+// identifying string for the socket, which is passed to `oc` when the socket is about to be closed.
+// If you do not care about the callback, pass nil instead. How the socket handles a client that
+// can't keep up is read from the request, see backpressureFromRequest.
+func NewSSESocket(name string, w http.ResponseWriter, r *http.Request, oc func(string)) (*sseSocket, error) {
+	bp, err := backpressureFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return nil, err
+	}
+
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return nil, errors.New("ResponseWriter does not support flushing")
+	}
+
+	if oc == nil {
+		oc = func(string) {}
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	s := &sseSocket{
+		name:      name,
+		w:         w,
+		flusher:   flusher,
+		gone:      r.Context().Done(),
+		done:      make(chan bool),
+		closeLock: &sync.Mutex{},
+		onClose:   oc,
+	}
+	s.out = newOutbox(bp, func() {
+		log.Println("["+s.name+"]", "Closing event stream that missed", bp.MaxMissed, "messages")
+		s.Close()
+	})
+	return s, nil
+}
+
+func (s *sseSocket) Write(payload []byte) {
+	s.out.push(payload)
+}
+
+func (s *sseSocket) Close() {
+	s.closeLock.Lock()
+	if s.closed {
+		s.closeLock.Unlock()
+		return
+	}
+	s.closed = true
+	s.closeLock.Unlock()
+
+	if dropped := s.out.droppedCount(); dropped > 0 {
+		debugLog("["+s.name+"]", "Dropped", dropped, "messages")
+	}
+	s.onClose(s.name)
+	close(s.done)
+}
+
+func (s *sseSocket) GetName() string {
+	return s.name
+}
+
+// Serve writes the given backlog of requests to the stream, followed by any messages written to
+// the socket, until either the client goes away or the socket is closed. Messages for requests
+// that were part of the backlog are skipped.
+func (s *sseSocket) Serve(backlog []*GeobinRequest) {
+	defer s.Close()
+
+	sent := make(map[string]bool)
+	for _, gr := range backlog {
+		payload, err := json.Marshal(gr)
+		if err != nil {
+			continue
+		}
+		if err := s.writeEvent(gr.ID, payload); err != nil {
+			return
+		}
+		sent[gr.ID] = true
+	}
+
+	ticker := time.NewTicker(sseHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-s.gone:
+			return
+		case message := <-s.out.ch:
+			id := payloadID(message)
+			if id != "" && sent[id] {
+				continue
+			}
+			if err := s.writeEvent(id, message); err != nil {
+				return
+			}
+		case <-ticker.C:
+			if _, err := fmt.Fprint(s.w, ": ping\n\n"); err != nil {
+				return
+			}
+			s.flusher.Flush()
+		}
+	}
+}
+
+// writeEvent writes a single event holding the given payload, with the given event ID if any.
+func (s *sseSocket) writeEvent(id string, payload []byte) error {
+	var event string
+	if id != "" {
+		event = "id: " + id + "\n"
+	}
+	for _, line := range strings.Split(string(payload), "\n") {
+		event += "data: " + line + "\n"
+	}
+
+	if _, err := fmt.Fprint(s.w, event+"\n"); err != nil {
+		return err
+	}
+	s.flusher.Flush()
+	return nil
+}
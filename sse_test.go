@@ -0,0 +1,71 @@
+package main
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/bmizerany/assert"
+)
+
+func TestSSESocket(t *testing.T) {
+	closed := make(chan string, 1)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, err := NewSSESocket("test_socket", w, r, func(name string) {
+			closed <- name
+		})
+		if err != nil {
+			t.Error("Error creating event stream:", err)
+			return
+		}
+
+		go func() {
+			// already sent as part of the backlog
+			s.Write([]byte(`{"id":"1-1","timestamp":1}`))
+			s.Write([]byte(`{"id":"2-2","timestamp":2}`))
+			time.Sleep(50 * time.Millisecond)
+			s.Close()
+		}()
+
+		s.Serve([]*GeobinRequest{{ID: "1-1", Timestamp: 1, Headers: map[string]string{}}})
+	}))
+	defer ts.Close()
+
+	resp, err := http.Get(ts.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	assert.Equal(t, "text/event-stream", resp.Header.Get("Content-Type"))
+
+	lines := make([]string, 0)
+	scanner := bufio.NewScanner(resp.Body)
+	for scanner.Scan() {
+		lines = append(lines, scanner.Text())
+	}
+
+	assert.Equal(t, []string{
+		"id: 1-1",
+		`data: {"id":"1-1","timestamp":1,"headers":{},"body":""}`,
+		"",
+		"id: 2-2",
+		`data: {"id":"2-2","timestamp":2}`,
+		"",
+	}, lines)
+	assert.Equal(t, "test_socket", <-closed)
+}
+
+func TestSSEWriteEventMultiline(t *testing.T) {
+	r, _ := http.NewRequest("GET", "http://testing.geobin.io/api/1/sse/bin", nil)
+	w := httptest.NewRecorder()
+	s, err := NewSSESocket("test_socket", w, r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s.writeEvent("", []byte("one\ntwo"))
+	assert.T(t, strings.HasSuffix(w.Body.String(), "data: one\ndata: two\n\n"))
+}
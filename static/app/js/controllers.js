@@ -76,9 +76,31 @@
     });
 
     api.ws.open(binId, function(event) {
-      $scope.isNew = true;
       try {
         var data = JSON.parse(event.data);
+        // control messages from the server, like notices of missed requests, have a type
+        if (data.type === 'presence') {
+          $scope.$apply(function(){
+            $scope.viewers = data.viewers;
+          });
+          return;
+        }
+        if (data.type === 'deleted') {
+          $scope.$apply(function(){
+            $scope.validBin = false;
+          });
+          return;
+        }
+        if (data.type === 'purged') {
+          // start over with what's left of the history
+          window.location.reload();
+          return;
+        }
+        if (data.type) {
+          console.warn('Websocket notice:', data);
+          return;
+        }
+        $scope.isNew = true;
         $scope.$apply(function(){
           $scope.history.push(data);
           $scope.toggleGeo(data);
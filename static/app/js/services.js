@@ -76,7 +76,7 @@
 
       for (var i = h.length - 1; i >= 0; i--) {
         var diff = h[i].expires - n;
-        if (diff < 1) {
+        if (h[i].expires && diff < 1) {
           h.splice(i, 1);
         }
       }
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// Defaults used for dwell detection when none are given in the track options.
+const (
+	defaultDwellRadius = 25.0 // meters
+	defaultDwellTime   = 60.0 // seconds
+)
+
+// TrackOptions controls how the points stored in a bin are assembled into tracks.
+type TrackOptions struct {
+	// Dot separated key path to the device ID, e.g. "vehicle.id". The path is looked up starting at
+	// the object that holds the point and then at each of its parents up to the root of the body.
+	// Points without a device ID are grouped into a single track with an empty device ID.
+	DeviceKey string `json:"deviceKey"`
+	// How to order the points in a track, either "device" to use the device timestamp (falling back
+	// to the receive time when a point has none) or "received" to use the time the request came in.
+	Order string `json:"order"`
+	// A dwell is detected when a device stays within DwellRadius meters for at least DwellTime seconds.
+	DwellRadius float64 `json:"dwellRadius"`
+	DwellTime   float64 `json:"dwellTime"`
+}
+
+// validate checks that the order, if given, is one of "device" or "received".
+func (opts TrackOptions) validate() error {
+	if opts.Order != "" && opts.Order != "device" && opts.Order != "received" {
+		return errors.New("order must be device or received.")
+	}
+	return nil
+}
+
+// trackPoint is a single position reported by a device
+type trackPoint struct {
+	lng, lat float64
+	time     int64 // Unix time (milis)
+}
+
+// byTime sorts track points by time, oldest first
+type byTime []trackPoint
+
+func (p byTime) Len() int           { return len(p) }
+func (p byTime) Swap(i, j int)      { p[i], p[j] = p[j], p[i] }
+func (p byTime) Less(i, j int) bool { return p[i].time < p[j].time }
+
+// assembleTracks groups the point geometries found in the given history by device and returns a
+// GeoJSON FeatureCollection with one Feature per device. Devices with more than one point get a
+// LineString with per-segment distance, duration and speed and any dwells that were detected.
+// The history is expected newest first, as it is stored. Fence events are left out.
+func assembleTracks(history []*GeobinRequest, opts TrackOptions) map[string]interface{} {
+	if opts.DwellRadius <= 0 {
+		opts.DwellRadius = defaultDwellRadius
+	}
+	if opts.DwellTime <= 0 {
+		opts.DwellTime = defaultDwellTime
+	}
+	keyPath := splitKeyPath(opts.DeviceKey)
+
+	devices := make([]string, 0)
+	points := make(map[string][]trackPoint)
+	for i := len(history) - 1; i >= 0; i-- {
+		gr := history[i]
+		// fence events carry the Geo of the request that triggered them, which is already here
+		if gr.Event != nil {
+			continue
+		}
+
+		var body interface{}
+		if keyPath != nil {
+			json.Unmarshal([]byte(gr.Body), &body)
+		}
+
+		for _, g := range gr.Geo {
+			lng, lat, ok := pointCoords(g.Geo)
+			if !ok {
+				continue
+			}
+
+			p := trackPoint{lng: lng, lat: lat, time: gr.Timestamp * 1000}
+			if opts.Order != "received" && g.Time != 0 {
+				p.time = g.Time
+			}
+
+			device := deviceID(body, g.Path, keyPath)
+			if _, ok := points[device]; !ok {
+				devices = append(devices, device)
+			}
+			points[device] = append(points[device], p)
+		}
+	}
+
+	features := make([]interface{}, 0, len(devices))
+	for _, device := range devices {
+		features = append(features, trackFeature(device, points[device], opts))
+	}
+
+	return map[string]interface{}{
+		"type":     "FeatureCollection",
+		"features": features,
+	}
+}
+
+// deviceID looks up the device ID for a point found at geoPath within body by following keyPath
+// from the point's object and each of its parents in turn.
+func deviceID(body interface{}, geoPath []interface{}, keyPath []string) string {
+	if keyPath == nil {
+		return ""
+	}
+
+	path := make([]string, len(geoPath))
+	for i, k := range geoPath {
+		path[i] = fmt.Sprint(k)
+	}
+
+	for i := len(path); i >= 0; i-- {
+		parent, ok := valueAtPath(body, path[:i])
+		if !ok {
+			continue
+		}
+		if v, ok := valueAtPath(parent, keyPath); ok && v != nil {
+			return fmt.Sprint(v)
+		}
+	}
+
+	return ""
+}
+
+// trackFeature builds the GeoJSON Feature for a single device's points.
+func trackFeature(device string, points []trackPoint, opts TrackOptions) map[string]interface{} {
+	sort.Stable(byTime(points))
+
+	props := map[string]interface{}{
+		"device": device,
+		"points": len(points),
+		"start":  points[0].time,
+		"end":    points[len(points)-1].time,
+	}
+
+	if len(points) == 1 {
+		props["distance"] = float64(0)
+		return map[string]interface{}{
+			"type": "Feature",
+			"geometry": map[string]interface{}{
+				"type":        "Point",
+				"coordinates": []interface{}{points[0].lng, points[0].lat},
+			},
+			"properties": props,
+		}
+	}
+
+	dwells, inDwell := detectDwells(points, opts)
+
+	coords := make([]interface{}, 0, len(points))
+	segments := make([]interface{}, 0, len(points)-1)
+	var total float64
+	for i, p := range points {
+		coords = append(coords, []interface{}{p.lng, p.lat})
+		if i == 0 {
+			continue
+		}
+
+		prev := points[i-1]
+		dist := distance(prev.lng, prev.lat, p.lng, p.lat)
+		duration := float64(p.time-prev.time) / 1000
+		total += dist
+
+		segment := map[string]interface{}{
+			"distance": dist,
+			"duration": duration,
+			"dwell":    inDwell[i-1],
+		}
+		if duration > 0 {
+			segment["speed"] = dist / duration
+		}
+		segments = append(segments, segment)
+	}
+
+	props["distance"] = total
+	props["segments"] = segments
+	props["dwells"] = dwells
+
+	return map[string]interface{}{
+		"type": "Feature",
+		"geometry": map[string]interface{}{
+			"type":        "LineString",
+			"coordinates": coords,
+		},
+		"properties": props,
+	}
+}
+
+// detectDwells finds the runs of points that stay within opts.DwellRadius of the first point of
+// the run for at least opts.DwellTime. It returns a description of each dwell and, for each
+// segment, whether or not that segment is part of a dwell.
+func detectDwells(points []trackPoint, opts TrackOptions) ([]interface{}, []bool) {
+	dwells := make([]interface{}, 0)
+	inDwell := make([]bool, len(points)-1)
+
+	for i := 0; i < len(points)-1; {
+		anchor := points[i]
+		j := i + 1
+		for j < len(points) && distance(anchor.lng, anchor.lat, points[j].lng, points[j].lat) <= opts.DwellRadius {
+			j++
+		}
+
+		last := points[j-1]
+		duration := float64(last.time-anchor.time) / 1000
+		if j-1 > i && duration >= opts.DwellTime {
+			dwells = append(dwells, map[string]interface{}{
+				"start":       anchor.time,
+				"end":         last.time,
+				"duration":    duration,
+				"points":      j - i,
+				"coordinates": []interface{}{anchor.lng, anchor.lat},
+			})
+			for k := i; k < j-1; k++ {
+				inDwell[k] = true
+			}
+			i = j - 1
+			continue
+		}
+
+		i++
+	}
+
+	return dwells, inDwell
+}
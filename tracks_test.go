@@ -0,0 +1,161 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/bmizerany/assert"
+)
+
+// trackHistory builds a newest first history out of the given bodies, which are received one
+// second apart, oldest first.
+func trackHistory(bodies ...string) []*GeobinRequest {
+	history := make([]*GeobinRequest, len(bodies))
+	for i, b := range bodies {
+		history[len(bodies)-1-i] = NewGeobinRequest(int64(1000+i), nil, []byte(b))
+	}
+	return history
+}
+
+func trackFeatures(t *testing.T, fc map[string]interface{}) []map[string]interface{} {
+	assert.Equal(t, "FeatureCollection", fc["type"])
+	features := make([]map[string]interface{}, 0)
+	for _, f := range fc["features"].([]interface{}) {
+		features = append(features, f.(map[string]interface{}))
+	}
+	return features
+}
+
+func TestTrackOptionsValidate(t *testing.T) {
+	for _, order := range []string{"", "device", "received"} {
+		assert.Equal(t, nil, TrackOptions{Order: order}.validate(), order)
+	}
+	assert.NotEqual(t, nil, TrackOptions{Order: "recieved"}.validate())
+}
+
+func TestAssembleTracksSingleDevice(t *testing.T) {
+	history := trackHistory(
+		`{"lat": 0, "lng": 0}`,
+		`{"lat": 0, "lng": 0.001}`,
+		`{"lat": 0, "lng": 0.002}`,
+	)
+
+	features := trackFeatures(t, assembleTracks(history, TrackOptions{}))
+	assert.Equal(t, 1, len(features))
+
+	f := features[0]
+	geom := f["geometry"].(map[string]interface{})
+	assert.Equal(t, "LineString", geom["type"])
+	assert.Equal(t, []interface{}{
+		[]interface{}{float64(0), float64(0)},
+		[]interface{}{0.001, float64(0)},
+		[]interface{}{0.002, float64(0)},
+	}, geom["coordinates"])
+
+	props := f["properties"].(map[string]interface{})
+	assert.Equal(t, "", props["device"])
+	assert.Equal(t, 3, props["points"])
+	assert.Equal(t, int64(1000000), props["start"])
+	assert.Equal(t, int64(1002000), props["end"])
+	assertCloseTo(t, 222.4, props["distance"].(float64), 0.1)
+
+	segments := props["segments"].([]interface{})
+	assert.Equal(t, 2, len(segments))
+	seg := segments[0].(map[string]interface{})
+	assert.Equal(t, float64(1), seg["duration"])
+	assertCloseTo(t, 111.2, seg["speed"].(float64), 0.1)
+	assert.Equal(t, false, seg["dwell"])
+}
+
+func TestAssembleTracksSkipsEvents(t *testing.T) {
+	history := trackHistory(
+		`{"lat": 0, "lng": 0}`,
+		`{"lat": 0, "lng": 0.001}`,
+	)
+	event := &GeobinRequest{Timestamp: 1001, Geo: history[0].Geo, Event: &FenceEvent{Type: "enter", Fence: "a"}}
+	history = append([]*GeobinRequest{event}, history...)
+
+	features := trackFeatures(t, assembleTracks(history, TrackOptions{}))
+	assert.Equal(t, 1, len(features))
+	props := features[0]["properties"].(map[string]interface{})
+	assert.Equal(t, 2, props["points"])
+}
+
+func TestAssembleTracksByDevice(t *testing.T) {
+	history := trackHistory(
+		`{"vehicle": {"id": "a"}, "location": {"lat": 1, "lng": 1}}`,
+		`[{"vehicle": {"id": "b"}, "lat": 2, "lng": 2}, {"vehicle": {"id": "a"}, "lat": 1.1, "lng": 1.1}]`,
+		`{"vehicle": {"id": "b"}, "location": {"lat": 2.1, "lng": 2.1}}`,
+		`{"lat": 3, "lng": 3}`,
+	)
+
+	devices := func(opts TrackOptions) map[string]int {
+		d := make(map[string]int)
+		for _, f := range trackFeatures(t, assembleTracks(history, opts)) {
+			props := f["properties"].(map[string]interface{})
+			d[props["device"].(string)] = props["points"].(int)
+		}
+		return d
+	}
+
+	assert.Equal(t, map[string]int{"a": 2, "b": 2, "": 1}, devices(TrackOptions{DeviceKey: "vehicle.id"}))
+	assert.Equal(t, map[string]int{"": 5}, devices(TrackOptions{}))
+	assert.Equal(t, map[string]int{"": 5}, devices(TrackOptions{DeviceKey: "missing"}))
+
+	// tracks are listed in the order their devices were first seen
+	features := trackFeatures(t, assembleTracks(history, TrackOptions{DeviceKey: "vehicle.id"}))
+	assert.Equal(t, "a", features[0]["properties"].(map[string]interface{})["device"])
+}
+
+func TestAssembleTracksOrder(t *testing.T) {
+	// received in the opposite order to the device timestamps
+	history := trackHistory(
+		`{"lat": 0, "lng": 0.002, "ts": 1400000002}`,
+		`{"lat": 0, "lng": 0.001, "ts": 1400000001}`,
+		`{"lat": 0, "lng": 0, "ts": 1400000000}`,
+	)
+
+	first := func(opts TrackOptions) interface{} {
+		features := trackFeatures(t, assembleTracks(history, opts))
+		coords := features[0]["geometry"].(map[string]interface{})["coordinates"].([]interface{})
+		return coords[0]
+	}
+
+	assert.Equal(t, []interface{}{float64(0), float64(0)}, first(TrackOptions{}))
+	assert.Equal(t, []interface{}{0.002, float64(0)}, first(TrackOptions{Order: "received"}))
+}
+
+func TestAssembleTracksDwell(t *testing.T) {
+	history := trackHistory(
+		`{"lat": 0, "lng": 0, "ts": 1400000000}`,
+		`{"lat": 0, "lng": 0.0001, "ts": 1400000060}`,
+		`{"lat": 0, "lng": 0, "ts": 1400000120}`,
+		`{"lat": 0, "lng": 0.01, "ts": 1400000180}`,
+	)
+
+	features := trackFeatures(t, assembleTracks(history, TrackOptions{}))
+	props := features[0]["properties"].(map[string]interface{})
+
+	dwells := props["dwells"].([]interface{})
+	assert.Equal(t, 1, len(dwells))
+	dwell := dwells[0].(map[string]interface{})
+	assert.Equal(t, float64(120), dwell["duration"])
+	assert.Equal(t, 3, dwell["points"])
+
+	segments := props["segments"].([]interface{})
+	assert.Equal(t, true, segments[0].(map[string]interface{})["dwell"])
+	assert.Equal(t, true, segments[1].(map[string]interface{})["dwell"])
+	assert.Equal(t, false, segments[2].(map[string]interface{})["dwell"])
+
+	// a longer dwell time means no dwell is detected
+	features = trackFeatures(t, assembleTracks(history, TrackOptions{DwellTime: 300}))
+	dwells = features[0]["properties"].(map[string]interface{})["dwells"].([]interface{})
+	assert.Equal(t, 0, len(dwells))
+}
+
+func TestAssembleTracksSinglePoint(t *testing.T) {
+	features := trackFeatures(t, assembleTracks(trackHistory(`{"lat": 1, "lng": 2}`), TrackOptions{}))
+	assert.Equal(t, 1, len(features))
+	geom := features[0]["geometry"].(map[string]interface{})
+	assert.Equal(t, "Point", geom["type"])
+	assert.Equal(t, []interface{}{float64(2), float64(1)}, geom["coordinates"])
+}
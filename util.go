@@ -3,6 +3,8 @@ package main
 import (
 	"log"
 	"math/rand"
+	"strconv"
+	"strings"
 )
 
 // randomString returns a random string with the given length
@@ -37,6 +39,40 @@ func nameExists(name string) (bool, error) {
 	return resp.Val(), nil
 }
 
+// splitKeyPath splits a dot separated key path like "vehicle.id" or "points.0.x" into its keys.
+func splitKeyPath(path string) []string {
+	if path == "" {
+		return nil
+	}
+	return strings.Split(path, ".")
+}
+
+// valueAtPath follows the given keys down into js, using them as object keys or array indexes as
+// appropriate, and returns the value found there along with a boolean reflecting whether or not
+// the path exists.
+func valueAtPath(js interface{}, path []string) (interface{}, bool) {
+	for _, key := range path {
+		switch t := js.(type) {
+		case map[string]interface{}:
+			v, ok := t[key]
+			if !ok {
+				return nil, false
+			}
+			js = v
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(t) {
+				return nil, false
+			}
+			js = t[i]
+		default:
+			return nil, false
+		}
+	}
+
+	return js, true
+}
+
 // debugLog logs messages sent to it if and only if isDebug or isVerbose are set to true
 func debugLog(v ...interface{}) {
 	if *isDebug || *isVerbose {